@@ -0,0 +1,76 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+// basicAuthenticator authenticates the basic auth credentials from a file
+type basicAuthenticator struct {
+	// a map of the username to the password and user
+	users map[string]*basicUser
+}
+
+// basicUser is a user in the basic auth file
+type basicUser struct {
+	// the password of the user
+	password string
+	// the user itself
+	info *user.DefaultInfo
+}
+
+// NewBasicAuthenticator creates an authenticator from a csv basic auth file in the kubernetes
+// format, i.e. password,user,uid,"group1,group2"
+func NewBasicAuthenticator(path string) (Authenticator, error) {
+	credentials, err := parseCredentialsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]*basicUser, len(credentials))
+	for password, info := range credentials {
+		users[info.Name] = &basicUser{password: password, info: info}
+	}
+	glog.Infof("loaded %d users from the basic auth file: %s", len(users), path)
+
+	return &basicAuthenticator{users: users}, nil
+}
+
+// AuthenticateRequest validates the basic auth credentials of the request
+func (r *basicAuthenticator) AuthenticateRequest(req *http.Request) (user.Info, bool, error) {
+	username, password, found := req.BasicAuth()
+	if !found {
+		return nil, false, nil
+	}
+	account, found := r.users[username]
+	if !found || !secureCompare(account.password, password) {
+		return nil, false, fmt.Errorf("invalid username or password")
+	}
+
+	return account.info, true, nil
+}
+
+// secureCompare compares the secrets in constant time
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,37 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package auth
+
+import (
+	"net/http"
+
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+const (
+	// AnonymousUser is the user given to unauthenticated requests
+	AnonymousUser = "system:anonymous"
+	// AnonymousGroup is the group given to unauthenticated requests
+	AnonymousGroup = "system:unauthenticated"
+)
+
+// Authenticator authenticates the caller of a request
+type Authenticator interface {
+	// authenticate the request, returning false if the request carries no credentials for the authenticator
+	AuthenticateRequest(*http.Request) (user.Info, bool, error)
+}
@@ -0,0 +1,422 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+const (
+	// the clock skew permitted when validating the expiry
+	oidcClockSkew = time.Minute
+	// the minimum interval between refreshing the jwks from the url
+	oidcRefreshInterval = 5 * time.Minute
+)
+
+// OIDCOptions are the options for validating the id tokens
+type OIDCOptions struct {
+	// the issuer the tokens must be issued by
+	IssuerURL string
+	// the audience the tokens must be issued for
+	ClientID string
+	// the path or url of the json web key set
+	JWKS string
+	// the claim used as the username
+	UsernameClaim string
+	// the claim used as the groups
+	GroupsClaim string
+}
+
+// oidcAuthenticator validates the id tokens against the json web key set
+type oidcAuthenticator struct {
+	sync.RWMutex
+	// the options for the authenticator
+	options OIDCOptions
+	// the public keys indexed by the key id
+	keys map[string]crypto.PublicKey
+	// the time the keys were last refreshed
+	refreshed time.Time
+}
+
+// jsonWebKey is a public key in the json web key set
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	// the rsa modulus and exponent
+	N string `json:"n"`
+	E string `json:"e"`
+	// the elliptic curve and coordinates
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// jwtHeader is the header of the json web token
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// NewOIDCAuthenticator creates an authenticator validating the bearer id tokens, the json web key set
+// is read from a file or a url
+func NewOIDCAuthenticator(options OIDCOptions) (Authenticator, error) {
+	if options.IssuerURL == "" || options.ClientID == "" || options.JWKS == "" {
+		return nil, fmt.Errorf("the oidc issuer, client id and jwks must be specified")
+	}
+	if options.UsernameClaim == "" {
+		options.UsernameClaim = "sub"
+	}
+	authenticator := &oidcAuthenticator{options: options}
+	if err := authenticator.refreshKeys(); err != nil {
+		return nil, err
+	}
+
+	return authenticator, nil
+}
+
+// AuthenticateRequest validates the bearer token of the request
+func (r *oidcAuthenticator) AuthenticateRequest(req *http.Request) (user.Info, bool, error) {
+	token, found := BearerToken(req)
+	if !found || strings.Count(token, ".") != 2 {
+		return nil, false, nil
+	}
+	claims, err := r.verify(token)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid id token, %s", err)
+	}
+	if err := r.validateClaims(claims); err != nil {
+		return nil, false, fmt.Errorf("invalid id token, %s", err)
+	}
+
+	// step: extract the user and groups
+	username, ok := claims[r.options.UsernameClaim].(string)
+	if !ok || username == "" {
+		return nil, false, fmt.Errorf("invalid id token, the claim: %s is missing", r.options.UsernameClaim)
+	}
+	identity := &user.DefaultInfo{Name: username}
+	if subject, ok := claims["sub"].(string); ok {
+		identity.UID = subject
+	}
+	if r.options.GroupsClaim != "" {
+		switch groups := claims[r.options.GroupsClaim].(type) {
+		case string:
+			identity.Groups = []string{groups}
+		case []interface{}:
+			for _, x := range groups {
+				if group, ok := x.(string); ok {
+					identity.Groups = append(identity.Groups, group)
+				}
+			}
+		}
+	}
+
+	return identity, true, nil
+}
+
+// verify checks the signature of the token and returns the claims
+func (r *oidcAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+
+	header := new(jwtHeader)
+	if err := decodeSegment(parts[0], header); err != nil {
+		return nil, fmt.Errorf("invalid header, %s", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding, %s", err)
+	}
+
+	key, err := r.publicKey(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Algorithm, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{}, 0)
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims, %s", err)
+	}
+
+	return claims, nil
+}
+
+// validateClaims checks the issuer, audience and expiry of the token
+func (r *oidcAuthenticator) validateClaims(claims map[string]interface{}) error {
+	if issuer, _ := claims["iss"].(string); issuer != r.options.IssuerURL {
+		return fmt.Errorf("unexpected issuer: %s", issuer)
+	}
+
+	audienced := false
+	switch audience := claims["aud"].(type) {
+	case string:
+		audienced = audience == r.options.ClientID
+	case []interface{}:
+		for _, x := range audience {
+			if x == r.options.ClientID {
+				audienced = true
+			}
+		}
+	}
+	if !audienced {
+		return fmt.Errorf("the token was not issued for the client: %s", r.options.ClientID)
+	}
+
+	now := time.Now()
+	expiry, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("the token has no expiry")
+	}
+	if now.Add(-oidcClockSkew).After(time.Unix(int64(expiry), 0)) {
+		return fmt.Errorf("the token has expired")
+	}
+	if notBefore, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(notBefore), 0)) {
+		return fmt.Errorf("the token is not valid yet")
+	}
+
+	return nil
+}
+
+// publicKey retrieves the key, refreshing the key set from the url when the key is unknown
+func (r *oidcAuthenticator) publicKey(id string) (crypto.PublicKey, error) {
+	r.RLock()
+	key, found := r.findKey(id)
+	refreshed := r.refreshed
+	r.RUnlock()
+	if found {
+		return key, nil
+	}
+
+	// step: the keys may have been rotated, refresh if permitted
+	if isURL(r.options.JWKS) && time.Since(refreshed) > oidcRefreshInterval {
+		if err := r.refreshKeys(); err != nil {
+			glog.Errorf("unable to refresh the jwks from: %s, error: %s", r.options.JWKS, err)
+		}
+		r.RLock()
+		key, found = r.findKey(id)
+		r.RUnlock()
+		if found {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key: %s", id)
+}
+
+// findKey looks for the key, a token without a key id is permitted only a single key exists
+func (r *oidcAuthenticator) findKey(id string) (crypto.PublicKey, bool) {
+	if id == "" && len(r.keys) == 1 {
+		for _, key := range r.keys {
+			return key, true
+		}
+	}
+	key, found := r.keys[id]
+
+	return key, found
+}
+
+// refreshKeys reads in the json web key set from the file or url
+func (r *oidcAuthenticator) refreshKeys() error {
+	var content []byte
+	var err error
+
+	if isURL(r.options.JWKS) {
+		var resp *http.Response
+		resp, err = (&http.Client{Timeout: 10 * time.Second}).Get(r.options.JWKS)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("the jwks url responded with %d", resp.StatusCode)
+		}
+		content, err = ioutil.ReadAll(resp.Body)
+	} else {
+		content, err = ioutil.ReadFile(r.options.JWKS)
+	}
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(content)
+	if err != nil {
+		return err
+	}
+	glog.Infof("loaded %d signing keys from the jwks: %s", len(keys), r.options.JWKS)
+
+	r.Lock()
+	defer r.Unlock()
+	r.keys = keys
+	r.refreshed = time.Now()
+
+	return nil
+}
+
+// parseJWKS decodes the rsa and elliptic curve signing keys from the json web key set
+func parseJWKS(content []byte) (map[string]crypto.PublicKey, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("invalid jwks, %s", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, 0)
+	for _, x := range set.Keys {
+		if x.Use != "" && x.Use != "sig" {
+			continue
+		}
+		switch x.KeyType {
+		case "RSA":
+			n, err := decodeBigInt(x.N)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rsa key: %s, %s", x.KeyID, err)
+			}
+			e, err := decodeBigInt(x.E)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rsa key: %s, %s", x.KeyID, err)
+			}
+			keys[x.KeyID] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch x.Curve {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("unsupported curve: %s, key: %s", x.Curve, x.KeyID)
+			}
+			px, err := decodeBigInt(x.X)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ec key: %s, %s", x.KeyID, err)
+			}
+			py, err := decodeBigInt(x.Y)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ec key: %s, %s", x.KeyID, err)
+			}
+			keys[x.KeyID] = &ecdsa.PublicKey{Curve: curve, X: px, Y: py}
+		default:
+			glog.Warningf("ignoring the unsupported key type: %s, key: %s", x.KeyType, x.KeyID)
+		}
+	}
+	if len(keys) <= 0 {
+		return nil, fmt.Errorf("the jwks has no signing keys")
+	}
+
+	return keys, nil
+}
+
+// verifySignature verifies the signature of the signed content with the algorithm
+func verifySignature(algorithm string, key crypto.PublicKey, signed, signature []byte) error {
+	if len(algorithm) != 5 {
+		return fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+	var hash crypto.Hash
+	switch algorithm[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(algorithm, "RS"):
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("the key does not match the algorithm: %s", algorithm)
+		}
+		return rsa.VerifyPKCS1v15(publicKey, hash, digest, signature)
+	case strings.HasPrefix(algorithm, "PS"):
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("the key does not match the algorithm: %s", algorithm)
+		}
+		return rsa.VerifyPSS(publicKey, hash, digest, signature, nil)
+	case strings.HasPrefix(algorithm, "ES"):
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("the key does not match the algorithm: %s", algorithm)
+		}
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature length")
+		}
+		rs := new(big.Int).SetBytes(signature[:size])
+		ss := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(publicKey, digest, rs, ss) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported algorithm: %s", algorithm)
+}
+
+// decodeSegment decodes a base64 url encoded json segment of the token
+func decodeSegment(segment string, data interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, data)
+}
+
+// decodeBigInt decodes a base64 url encoded big endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	content, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(content), nil
+}
+
+// isURL checks if the location is a http url rather than a file
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://")
+}
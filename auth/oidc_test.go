@@ -0,0 +1,135 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signTestToken signs the claims with the key as an ES256 id token
+func signTestToken(t *testing.T, key *ecdsa.PrivateKey, algorithm, id string, claims map[string]interface{}) string {
+	encode := func(data interface{}) string {
+		content, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(content)
+	}
+	signed := encode(map[string]string{"alg": algorithm, "kid": id}) + "." + encode(claims)
+
+	digest := sha256.Sum256([]byte(signed))
+	rs, ss, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	rs.FillBytes(signature[:32])
+	ss.FillBytes(signature[32:])
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCAuthenticateRequest(t *testing.T) {
+	trusted, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	untrusted, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	content := fmt.Sprintf(`{"keys":[{"kty":"EC","kid":"k1","use":"sig","crv":"P-256","x":%q,"y":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(trusted.X.FillBytes(make([]byte, 32))),
+		base64.RawURLEncoding.EncodeToString(trusted.Y.FillBytes(make([]byte, 32))))
+	if err := ioutil.WriteFile(jwks, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewOIDCAuthenticator(OIDCOptions{
+		IssuerURL:     "https://issuer.example.com",
+		ClientID:      "kube-cover",
+		JWKS:          jwks,
+		UsernameClaim: "email",
+		GroupsClaim:   "groups",
+	})
+	if err != nil {
+		t.Fatalf("unable to create the authenticator, error: %s", err)
+	}
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		values := map[string]interface{}{
+			"iss":    "https://issuer.example.com",
+			"aud":    "kube-cover",
+			"sub":    "1234",
+			"email":  "alice@example.com",
+			"groups": []string{"dev"},
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(values, k)
+				continue
+			}
+			values[k] = v
+		}
+		return values
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		user  string
+		found bool
+		err   bool
+	}{
+		{name: "valid", token: signTestToken(t, trusted, "ES256", "k1", claims(nil)), user: "alice@example.com", found: true},
+		{name: "audience list", token: signTestToken(t, trusted, "ES256", "k1", claims(map[string]interface{}{"aud": []string{"other", "kube-cover"}})), user: "alice@example.com", found: true},
+		{name: "no key id, single key", token: signTestToken(t, trusted, "ES256", "", claims(nil)), user: "alice@example.com", found: true},
+		{name: "signed by a key outside the jwks", token: signTestToken(t, untrusted, "ES256", "k1", claims(nil)), err: true},
+		{name: "unknown key id", token: signTestToken(t, untrusted, "ES256", "k2", claims(nil)), err: true},
+		{name: "algorithm none", token: signTestToken(t, trusted, "none", "k1", claims(nil)), err: true},
+		{name: "rsa algorithm with an ec key", token: signTestToken(t, trusted, "RS256", "k1", claims(nil)), err: true},
+		{name: "wrong issuer", token: signTestToken(t, trusted, "ES256", "k1", claims(map[string]interface{}{"iss": "https://evil.example.com"})), err: true},
+		{name: "wrong audience", token: signTestToken(t, trusted, "ES256", "k1", claims(map[string]interface{}{"aud": "other"})), err: true},
+		{name: "expired", token: signTestToken(t, trusted, "ES256", "k1", claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})), err: true},
+		{name: "no expiry", token: signTestToken(t, trusted, "ES256", "k1", claims(map[string]interface{}{"exp": nil})), err: true},
+		{name: "not valid yet", token: signTestToken(t, trusted, "ES256", "k1", claims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})), err: true},
+		{name: "no username claim", token: signTestToken(t, trusted, "ES256", "k1", claims(map[string]interface{}{"email": nil})), err: true},
+		{name: "not a jwt", token: "opaque-token"},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", "/api", nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		identity, found, err := authenticator.AuthenticateRequest(req)
+		if (err != nil) != c.err {
+			t.Errorf("case %s: expected an error: %t, got: %v", c.name, c.err, err)
+		}
+		if found != c.found {
+			t.Errorf("case %s: expected found: %t, got: %t", c.name, c.found, found)
+		}
+		if found && (identity.GetName() != c.user || identity.GetUID() != "1234" || len(identity.GetGroups()) != 1) {
+			t.Errorf("case %s: unexpected identity: %v", c.name, identity)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package auth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+// tokenAuthenticator authenticates the bearer tokens from a static token file
+type tokenAuthenticator struct {
+	// a map of token to the user
+	tokens map[string]*user.DefaultInfo
+}
+
+// NewTokenFileAuthenticator creates an authenticator from a csv token file in the kubernetes
+// format, i.e. token,user,uid,"group1,group2"
+func NewTokenFileAuthenticator(path string) (Authenticator, error) {
+	tokens, err := parseCredentialsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("loaded %d tokens from the token file: %s", len(tokens), path)
+
+	return &tokenAuthenticator{tokens: tokens}, nil
+}
+
+// AuthenticateRequest looks up the bearer token of the request
+func (r *tokenAuthenticator) AuthenticateRequest(req *http.Request) (user.Info, bool, error) {
+	token, found := BearerToken(req)
+	if !found {
+		return nil, false, nil
+	}
+	identity, found := r.tokens[token]
+	if !found {
+		return nil, false, fmt.Errorf("invalid bearer token")
+	}
+
+	return identity, true, nil
+}
+
+// BearerToken extracts the bearer token from the authorization header
+func BearerToken(req *http.Request) (string, bool) {
+	parts := strings.SplitN(strings.TrimSpace(req.Header.Get("Authorization")), " ", 2)
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return "", false
+	}
+	token := strings.TrimSpace(parts[1])
+
+	return token, token != ""
+}
+
+// parseCredentialsFile parses the csv credentials file, the secret being the first column followed by
+// the user, uid and optional groups
+func parseCredentialsFile(path string) (map[string]*user.DefaultInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	credentials := make(map[string]*user.DefaultInfo, 0)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("the file: %s, line: %d must have at least three columns", path, line)
+		}
+
+		identity := &user.DefaultInfo{Name: record[1], UID: record[2]}
+		if len(record) >= 4 && record[3] != "" {
+			identity.Groups = strings.Split(record[3], ",")
+		}
+		credentials[record[0]] = identity
+	}
+
+	return credentials, nil
+}
@@ -0,0 +1,181 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+const (
+	// the number of cached reviews before the expired are purged
+	tokenReviewCachePurge = 4096
+)
+
+// errTokenRejected is returned when the token review rejects the token
+var errTokenRejected = errors.New("the token was rejected by the token review")
+
+// TokenReview is the authentication.k8s.io token review
+type TokenReview struct {
+	unversioned.TypeMeta `json:",inline"`
+	// the token to review
+	Spec TokenReviewSpec `json:"spec"`
+	// the result of the review
+	Status TokenReviewStatus `json:"status,omitempty"`
+}
+
+// TokenReviewSpec is the token being reviewed
+type TokenReviewSpec struct {
+	// the bearer token
+	Token string `json:"token"`
+}
+
+// TokenReviewStatus is the result of the token review
+type TokenReviewStatus struct {
+	// whether the token was authenticated
+	Authenticated bool `json:"authenticated"`
+	// the user of the token
+	User TokenReviewUser `json:"user,omitempty"`
+	// the reason the token could not be authenticated
+	Error string `json:"error,omitempty"`
+}
+
+// TokenReviewUser is the user associated to the token
+type TokenReviewUser struct {
+	// the name of the user
+	Username string `json:"username"`
+	// the uid of the user
+	UID string `json:"uid"`
+	// the groups of the user
+	Groups []string `json:"groups"`
+}
+
+// TokenReviewer submits the token reviews to the api
+type TokenReviewer interface {
+	// submit the review, returning the completed review
+	Review(*TokenReview) (*TokenReview, error)
+}
+
+// tokenReviewAuthenticator authenticates the bearer tokens via the token review api
+type tokenReviewAuthenticator struct {
+	sync.Mutex
+	// the reviewer submitting the reviews
+	reviewer TokenReviewer
+	// the time to cache the authenticated tokens
+	successTTL time.Duration
+	// the time to cache the rejected tokens
+	failureTTL time.Duration
+	// the cached reviews indexed by the hash of the token
+	cache map[string]*cachedReview
+}
+
+// cachedReview is the cached result of a token review
+type cachedReview struct {
+	// the user, nil if the token was rejected
+	identity user.Info
+	// the time the review expires
+	expires time.Time
+}
+
+// NewTokenReviewAuthenticator creates an authenticator resolving the bearer tokens via the reviewer,
+// caching the authenticated and rejected tokens for the ttls
+func NewTokenReviewAuthenticator(reviewer TokenReviewer, successTTL, failureTTL time.Duration) Authenticator {
+	return &tokenReviewAuthenticator{
+		reviewer:   reviewer,
+		successTTL: successTTL,
+		failureTTL: failureTTL,
+		cache:      make(map[string]*cachedReview, 0),
+	}
+}
+
+// AuthenticateRequest reviews the bearer token of the request
+func (r *tokenReviewAuthenticator) AuthenticateRequest(req *http.Request) (user.Info, bool, error) {
+	token, found := BearerToken(req)
+	if !found {
+		return nil, false, nil
+	}
+	hash := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(hash[:])
+
+	// step: check the cache for the review
+	if cached, found := r.cached(key); found {
+		if cached.identity == nil {
+			return nil, false, errTokenRejected
+		}
+		return cached.identity, true, nil
+	}
+
+	review, err := r.reviewer.Review(&TokenReview{
+		TypeMeta: unversioned.TypeMeta{Kind: "TokenReview", APIVersion: "authentication.k8s.io/v1"},
+		Spec:     TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if !review.Status.Authenticated {
+		r.store(key, nil, r.failureTTL)
+		return nil, false, errTokenRejected
+	}
+
+	identity := &user.DefaultInfo{
+		Name:   review.Status.User.Username,
+		UID:    review.Status.User.UID,
+		Groups: review.Status.User.Groups,
+	}
+	r.store(key, identity, r.successTTL)
+
+	return identity, true, nil
+}
+
+// cached retrieves an unexpired review from the cache
+func (r *tokenReviewAuthenticator) cached(key string) (*cachedReview, bool) {
+	r.Lock()
+	defer r.Unlock()
+	cached, found := r.cache[key]
+	if !found || time.Now().After(cached.expires) {
+		return nil, false
+	}
+
+	return cached, true
+}
+
+// store adds the review to the cache, purging the expired reviews when the cache grows
+func (r *tokenReviewAuthenticator) store(key string, identity user.Info, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	if len(r.cache) >= tokenReviewCachePurge {
+		for k, x := range r.cache {
+			if now.After(x.expires) {
+				delete(r.cache, k)
+			}
+		}
+	}
+	r.cache[key] = &cachedReview{identity: identity, expires: now.Add(ttl)}
+}
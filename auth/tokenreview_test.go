@@ -0,0 +1,105 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeReviewer answers the token reviews, counting the reviews submitted
+type fakeReviewer struct {
+	// the users of the authenticated tokens
+	users map[string]string
+	// the error returned, if any
+	err error
+	// the number of reviews submitted
+	reviews int
+}
+
+// Review authenticates the tokens known to the reviewer
+func (r *fakeReviewer) Review(review *TokenReview) (*TokenReview, error) {
+	r.reviews++
+	if r.err != nil {
+		return nil, r.err
+	}
+	if username, found := r.users[review.Spec.Token]; found {
+		review.Status = TokenReviewStatus{Authenticated: true, User: TokenReviewUser{Username: username, Groups: []string{"system:serviceaccounts"}}}
+	}
+
+	return review, nil
+}
+
+func TestTokenReviewAuthenticator(t *testing.T) {
+	request := func(token string) *http.Request {
+		req, _ := http.NewRequest("GET", "/api", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+	// expire ages the cached reviews past their ttl
+	expire := func(authenticator Authenticator) {
+		for _, x := range authenticator.(*tokenReviewAuthenticator).cache {
+			x.expires = time.Now().Add(-time.Second)
+		}
+	}
+
+	cases := []struct {
+		name       string
+		successTTL time.Duration
+		failureTTL time.Duration
+		tokens     []string
+		expire     bool
+		err        error
+		reviews    int
+		user       string
+		found      bool
+		failed     bool
+	}{
+		{name: "authenticated is cached", successTTL: time.Minute, tokens: []string{"good", "good", "good"}, reviews: 1, user: "sa", found: true},
+		{name: "expired review is refetched", successTTL: time.Minute, tokens: []string{"good", "good"}, expire: true, reviews: 2, user: "sa", found: true},
+		{name: "authenticated not cached without ttl", tokens: []string{"good", "good"}, reviews: 2, user: "sa", found: true},
+		{name: "rejected is cached", failureTTL: time.Minute, tokens: []string{"bad", "bad"}, reviews: 1, failed: true},
+		{name: "expired rejection is refetched", failureTTL: time.Minute, tokens: []string{"bad", "bad"}, expire: true, reviews: 2, failed: true},
+		{name: "rejected not cached without ttl", successTTL: time.Minute, tokens: []string{"bad", "bad"}, reviews: 2, failed: true},
+		{name: "review failure is not cached", successTTL: time.Minute, failureTTL: time.Minute, tokens: []string{"good", "good"}, err: errors.New("unavailable"), reviews: 2, failed: true},
+		{name: "no token", successTTL: time.Minute, tokens: []string{""}},
+	}
+	for _, c := range cases {
+		reviewer := &fakeReviewer{users: map[string]string{"good": "sa"}, err: c.err}
+		authenticator := NewTokenReviewAuthenticator(reviewer, c.successTTL, c.failureTTL)
+		for i, token := range c.tokens {
+			if c.expire && i > 0 {
+				expire(authenticator)
+			}
+			identity, found, err := authenticator.AuthenticateRequest(request(token))
+			if found != c.found || (err != nil) != c.failed {
+				t.Errorf("case %s: expected found: %t, failed: %t, got: %t, %v", c.name, c.found, c.failed, found, err)
+			}
+			if found && identity.GetName() != c.user {
+				t.Errorf("case %s: expected the user: %s, got: %s", c.name, c.user, identity.GetName())
+			}
+		}
+		if reviewer.reviews != c.reviews {
+			t.Errorf("case %s: expected %d reviews, got: %d", c.name, c.reviews, reviewer.reviews)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+// unionAuthenticator tries each of the authenticators in turn
+type unionAuthenticator []Authenticator
+
+// NewUnionAuthenticator creates an authenticator returning the first successful authentication
+func NewUnionAuthenticator(authenticators ...Authenticator) Authenticator {
+	return unionAuthenticator(authenticators)
+}
+
+// AuthenticateRequest authenticates the request against each of the authenticators, the errors
+// are only returned if none of them succeeds
+func (r unionAuthenticator) AuthenticateRequest(req *http.Request) (user.Info, bool, error) {
+	var failures []string
+	for _, x := range r {
+		identity, found, err := x.AuthenticateRequest(req)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		if found {
+			return identity, true, nil
+		}
+	}
+	if len(failures) > 0 {
+		return nil, false, fmt.Errorf("%s", strings.Join(failures, ", "))
+	}
+
+	return nil, false, nil
+}
@@ -0,0 +1,123 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package authz
+
+import (
+	"net/http"
+	"strings"
+)
+
+// namespaceSubresources are the subresources of the namespace resource
+var namespaceSubresources = map[string]bool{"status": true, "finalize": true}
+
+// NewRequestAttributes derives the authorization attributes from a kubernetes api request, i.e.
+// /api/v1/namespaces/<namespace>/<resource>/<name>/<subresource>
+func NewRequestAttributes(req *http.Request) *Attributes {
+	attrs := &Attributes{Path: req.URL.Path}
+	parts := splitPath(req.URL.Path)
+
+	// step: strip the api prefix and version, the discovery of a version being a non resource path
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		attrs.APIGroup = parts[1]
+		parts = parts[3:]
+	default:
+		attrs.Verb = nonResourceVerb(req.Method)
+		return attrs
+	}
+	attrs.ResourceRequest = true
+
+	// step: handle the deprecated watch prefix
+	watching := req.URL.Query().Get("watch") == "true" || req.URL.Query().Get("watch") == "1"
+	if len(parts) > 0 && parts[0] == "watch" {
+		watching = true
+		parts = parts[1:]
+	}
+
+	// step: extract the namespace, bar the subresources of the namespace itself
+	if len(parts) >= 2 && parts[0] == "namespaces" {
+		attrs.Namespace = parts[1]
+		if len(parts) > 2 && !namespaceSubresources[parts[2]] {
+			parts = parts[2:]
+		}
+	}
+	if len(parts) > 0 {
+		attrs.Resource = parts[0]
+	}
+	if len(parts) > 1 {
+		attrs.Name = parts[1]
+	}
+	if len(parts) > 2 {
+		attrs.Subresource = parts[2]
+	}
+	attrs.Verb = resourceVerb(req.Method, attrs.Name, watching)
+
+	return attrs
+}
+
+// resourceVerb maps the method of a resource request to the kubernetes verb
+func resourceVerb(method, name string, watching bool) string {
+	switch method {
+	case "GET", "HEAD":
+		switch {
+		case watching:
+			return "watch"
+		case name == "":
+			return "list"
+		default:
+			return "get"
+		}
+	case "POST":
+		return "create"
+	case "PUT":
+		return "update"
+	case "PATCH":
+		return "patch"
+	case "DELETE":
+		if name == "" {
+			return "deletecollection"
+		}
+		return "delete"
+	}
+
+	return strings.ToLower(method)
+}
+
+// nonResourceVerb maps the method of a non resource request to the verb
+func nonResourceVerb(method string) string {
+	switch method {
+	case "HEAD":
+		return "get"
+	}
+
+	return strings.ToLower(method)
+}
+
+// splitPath splits the path into the non empty segments
+func splitPath(path string) []string {
+	var parts []string
+	for _, x := range strings.Split(path, "/") {
+		if x != "" {
+			parts = append(parts, x)
+		}
+	}
+
+	return parts
+}
@@ -0,0 +1,50 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package authz
+
+import (
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+// Attributes describes the request being authorized
+type Attributes struct {
+	// User is the caller of the request
+	User user.Info
+	// Verb is the kubernetes verb, i.e. get, list, watch, create
+	Verb string
+	// Namespace is the namespace of the resource, empty for cluster scoped requests
+	Namespace string
+	// APIGroup is the api group of the resource, empty for the core group
+	APIGroup string
+	// Resource is the resource, i.e. pods, secrets
+	Resource string
+	// Subresource is the subresource, i.e. log, exec
+	Subresource string
+	// Name is the name of the resource if any
+	Name string
+	// ResourceRequest indicates the request is for a resource rather a non resource url
+	ResourceRequest bool
+	// Path is the path of the request
+	Path string
+}
+
+// Authorizer decides if the request is permitted
+type Authorizer interface {
+	// authorize the request, returning an error describing the refusal
+	Authorize(*Attributes) error
+}
@@ -0,0 +1,198 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package authz
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gambol99/kube-cover/utils"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// Role permits the users and groups the verbs on the resources in the namespaces
+type Role struct {
+	// Users is the users the role is applied to, * being any user
+	Users []string `json:"users" yaml:"users"`
+	// Groups is the groups the role is applied to
+	Groups []string `json:"groups" yaml:"groups"`
+	// Verbs is the permitted verbs, i.e. get, list, watch
+	Verbs []string `json:"verbs" yaml:"verbs"`
+	// Resources is the permitted resources, i.e. pods, pods/log, deployments.extensions, * being all
+	Resources []string `json:"resources" yaml:"resources"`
+	// Namespaces is the namespaces of the resources, * covering all and the cluster scoped resources
+	Namespaces []string `json:"namespaces" yaml:"namespaces"`
+	// NonResourceURLs is the permitted non resource paths, a trailing * matching the prefix, i.e. /apis/*
+	NonResourceURLs []string `json:"nonResourceURLs" yaml:"nonresourceurls"`
+}
+
+// RoleList is the content of the role file
+type RoleList struct {
+	// Roles is a list of roles
+	Roles []*Role `json:"roles" yaml:"roles"`
+}
+
+// roleAuthorizer authorizes the requests against a static role file
+type roleAuthorizer struct {
+	roles []*Role
+}
+
+// NewRoleAuthorizer creates an authorizer from the roles in the yaml or json file, the requests not
+// permitted by any of the roles are refused
+func NewRoleAuthorizer(filename string) (Authorizer, error) {
+	if !utils.FileExists(filename) {
+		return nil, fmt.Errorf("file %s does not exist", filename)
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	list := new(RoleList)
+	switch filepath.Ext(filename) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(content, list)
+	case ".json":
+		err = json.Unmarshal(content, list)
+	default:
+		return nil, fmt.Errorf("unsupported extension and role file format")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, x := range list.Roles {
+		if err := x.isValid(); err != nil {
+			return nil, fmt.Errorf("role %d invalid, error: %s", i, err)
+		}
+	}
+	glog.Infof("loaded %d roles from the role file: %s", len(list.Roles), filename)
+
+	return &roleAuthorizer{roles: list.Roles}, nil
+}
+
+// Authorize checks if any of the roles applied to the user permits the request
+func (r *roleAuthorizer) Authorize(attrs *Attributes) error {
+	for _, x := range r.roles {
+		if x.matchesUser(attrs) && x.permits(attrs) {
+			return nil
+		}
+	}
+
+	name := "unknown"
+	if attrs.User != nil {
+		name = attrs.User.GetName()
+	}
+	if !attrs.ResourceRequest {
+		return fmt.Errorf("user %q cannot %s path %q", name, attrs.Verb, attrs.Path)
+	}
+	resource := attrs.Resource
+	if attrs.Subresource != "" {
+		resource = resource + "/" + attrs.Subresource
+	}
+	if attrs.Namespace == "" {
+		return fmt.Errorf("user %q cannot %s %s at the cluster scope", name, attrs.Verb, resource)
+	}
+
+	return fmt.Errorf("user %q cannot %s %s in the namespace %q", name, attrs.Verb, resource, attrs.Namespace)
+}
+
+// matchesUser checks if the role is applied to the user of the request
+func (r *Role) matchesUser(attrs *Attributes) bool {
+	if attrs.User == nil {
+		return false
+	}
+	if utils.ContainedIn("*", r.Users) || utils.ContainedIn(attrs.User.GetName(), r.Users) {
+		return true
+	}
+	for _, group := range attrs.User.GetGroups() {
+		if utils.ContainedIn(group, r.Groups) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// permits checks if the role permits the verb on the resource or path
+func (r *Role) permits(attrs *Attributes) bool {
+	if !utils.ContainedIn("*", r.Verbs) && !utils.ContainedIn(attrs.Verb, r.Verbs) {
+		return false
+	}
+	if !attrs.ResourceRequest {
+		for _, x := range r.NonResourceURLs {
+			if x == attrs.Path || (strings.HasSuffix(x, "*") && strings.HasPrefix(attrs.Path, strings.TrimSuffix(x, "*"))) {
+				return true
+			}
+		}
+		return false
+	}
+	if !utils.ContainedIn("*", r.Namespaces) && (attrs.Namespace == "" || !utils.ContainedIn(attrs.Namespace, r.Namespaces)) {
+		return false
+	}
+
+	return r.permitsResource(attrs)
+}
+
+// permitsResource checks if the resource or subresource is in the list, the resources of the
+// api groups being qualified by the group
+func (r *Role) permitsResource(attrs *Attributes) bool {
+	resource := attrs.Resource
+	if attrs.APIGroup != "" {
+		resource = resource + "." + attrs.APIGroup
+	}
+	if attrs.Subresource != "" {
+		resource = resource + "/" + attrs.Subresource
+	}
+	for _, x := range r.Resources {
+		if x == "*" {
+			return true
+		}
+		if matched, _ := path.Match(x, resource); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isValid checks the role is valid
+func (r *Role) isValid() error {
+	if len(r.Users) <= 0 && len(r.Groups) <= 0 {
+		return fmt.Errorf("the role does not have any users or groups")
+	}
+	if len(r.Verbs) <= 0 {
+		return fmt.Errorf("the role does not have any verbs")
+	}
+	if len(r.Resources) <= 0 && len(r.NonResourceURLs) <= 0 {
+		return fmt.Errorf("the role does not have any resources or non resource urls")
+	}
+	for _, x := range r.Resources {
+		if _, err := path.Match(x, ""); err != nil {
+			return fmt.Errorf("pattern: %s is invalid", x)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package authz
+
+import (
+	"net/http"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+func TestRoleAuthorizer(t *testing.T) {
+	authorizer := &roleAuthorizer{roles: []*Role{
+		{
+			Users:      []string{"alice"},
+			Verbs:      []string{"get", "list", "watch"},
+			Resources:  []string{"pods", "pods/log", "secrets"},
+			Namespaces: []string{"dev"},
+		},
+		{
+			Groups:     []string{"deployers"},
+			Verbs:      []string{"create", "update", "patch"},
+			Resources:  []string{"deployments.apps", "deployments.extensions"},
+			Namespaces: []string{"*"},
+		},
+		{
+			Users:           []string{"*"},
+			Verbs:           []string{"get"},
+			NonResourceURLs: []string{"/version", "/apis/*"},
+		},
+	}}
+	alice := &user.DefaultInfo{Name: "alice"}
+	bob := &user.DefaultInfo{Name: "bob", Groups: []string{"deployers"}}
+
+	cases := []struct {
+		user    user.Info
+		method  string
+		uri     string
+		allowed bool
+	}{
+		{user: alice, method: "GET", uri: "/api/v1/namespaces/dev/pods", allowed: true},
+		{user: alice, method: "GET", uri: "/api/v1/namespaces/dev/pods/web-1/log", allowed: true},
+		{user: alice, method: "GET", uri: "/api/v1/namespaces/dev/secrets/token", allowed: true},
+		{user: alice, method: "GET", uri: "/api/v1/namespaces/kube-system/secrets/token"},
+		{user: alice, method: "GET", uri: "/api/v1/secrets"},
+		{user: alice, method: "DELETE", uri: "/api/v1/namespaces/dev/pods/web-1"},
+		{user: alice, method: "POST", uri: "/api/v1/namespaces/dev/pods/web-1/exec"},
+		{user: alice, method: "GET", uri: "/api/v1/namespaces/dev/configmaps"},
+		{user: bob, method: "POST", uri: "/apis/apps/v1/namespaces/prod/deployments", allowed: true},
+		{user: bob, method: "PATCH", uri: "/apis/extensions/v1beta1/namespaces/dev/deployments/web", allowed: true},
+		{user: bob, method: "POST", uri: "/apis/apps/v1/namespaces/prod/statefulsets"},
+		{user: bob, method: "DELETE", uri: "/apis/apps/v1/namespaces/prod/deployments/web"},
+		{user: bob, method: "GET", uri: "/apis/apps/v1/namespaces/prod/deployments"},
+		{user: bob, method: "GET", uri: "/version", allowed: true},
+		{user: bob, method: "GET", uri: "/apis/apps/v1", allowed: true},
+		{user: bob, method: "GET", uri: "/api/v1"},
+		{user: bob, method: "GET", uri: "/healthz"},
+		{user: bob, method: "POST", uri: "/version"},
+		{method: "GET", uri: "/version"},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(c.method, c.uri, nil)
+		attrs := NewRequestAttributes(req)
+		attrs.User = c.user
+		err := authorizer.Authorize(attrs)
+		if allowed := err == nil; allowed != c.allowed {
+			t.Errorf("%s %s, user: %v, expected allowed: %t, got: %v", c.method, c.uri, c.user, c.allowed, err)
+		}
+	}
+}
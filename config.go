@@ -20,45 +20,239 @@ package main
 import (
 	"flag"
 	"fmt"
+	"time"
 )
 
 var config struct {
+	// the mode to run the service in
+	mode string
 	// the listening addrress
 	bindInterface string
 	// the path to the cerificate
 	certificateFile string
 	// the path the private ket
 	privateKeyFile string
-	// the upstream k8s url
+	// the path to the client ca bundle
+	clientCA string
+	// the path to the static token file
+	tokenAuthFile string
+	// the path to the basic auth file
+	basicAuthFile string
+	// the oidc issuer url
+	oidcIssuerURL string
+	// the oidc client id
+	oidcClientID string
+	// the oidc jwks file or url
+	oidcJWKS string
+	// the oidc username claim
+	oidcUsernameClaim string
+	// the oidc groups claim
+	oidcGroupsClaim string
+	// resolve tokens via the token review
+	tokenReview bool
+	// the ttl of the authenticated reviews
+	tokenReviewTTL time.Duration
+	// the ttl of the rejected reviews
+	tokenReviewNegativeTTL time.Duration
+	// permit the anonymous requests
+	anonymousAuth bool
+	// the path to the docker socket
+	dockerSocket string
+	// the pseudo namespace for docker
+	dockerNamespace string
+	// the kubelet paths permitted besides the streams and logs
+	kubeletAllowedPaths string
+	// the upstream k8s urls
 	upstreamURL string
+	// the balancing between the upstreams
+	upstreamBalance string
+	// the interval of the upstream health checks
+	upstreamHealthInterval time.Duration
+	// the time to wait for the upstream response headers
+	upstreamResponseTimeout time.Duration
+	// the total time of an upstream request
+	upstreamRequestTimeout time.Duration
+	// the total time of an upstream watch or log follow
+	upstreamStreamTimeout time.Duration
+	// the consecutive failures opening the circuit of an upstream
+	upstreamFailureThreshold int
+	// the time the circuit of an upstream is open
+	upstreamBreakerTimeout time.Duration
+	// the upstream ca bundle
+	upstreamCA string
+	// the upstream client certificate
+	upstreamClientCert string
+	// the upstream client private key
+	upstreamClientKey string
+	// the upstream bearer token
+	upstreamToken string
+	// skip verifying the upstream
+	upstreamInsecure bool
+	// the path to the kubeconfig
+	kubeconfig string
+	// the context in the kubeconfig
+	kubeContext string
+	// forward the caller identity upstream
+	impersonate bool
+	// the rate limit of the requests
+	rateLimit float64
+	// the burst of the requests
+	rateLimitBurst int
+	// the rate limit of the workload writes
+	writeRateLimit float64
+	// the burst of the workload writes
+	writeRateLimitBurst int
+	// the maximum size of the request bodies
+	maxBodySize int64
+	// the time the discovery responses are cached
+	discoveryCacheTTL time.Duration
+	// the write timeout of the responses
+	serverWriteTimeout time.Duration
+	// the idle timeout of the upgraded connections
+	upgradeIdleTimeout time.Duration
+	// the maximum duration of the upgraded connections
+	upgradeMaxDuration time.Duration
+	// the directory of the session recordings
+	sessionsDir string
 	// the path the policy file
 	policyFile string
+	// the path to the clusters file
+	clustersFile string
+	// the path to the role file
+	roleFile string
+	// the path to the shadow policy file
+	shadowPolicyFile string
+	// the interface to expose the metrics on
+	metricsBind string
+	// whether to run the pod reconciler
+	reconcile bool
+	// the action to take on non-compliant pods
+	reconcileAction string
+	// the grace period before taking action
+	reconcileGracePeriod time.Duration
 }
 
 func init() {
+	flag.StringVar(&config.mode, "mode", "proxy", "the mode to run the service, proxy in front of the api, an admission webhook, or proxy in front of the kubelet or docker")
 	flag.StringVar(&config.certificateFile, "tls-cert", "", "the path to the tls cerfiicate for the service to use")
 	flag.StringVar(&config.privateKeyFile, "tls-key", "", "the path to the tls private key for the service")
-	flag.StringVar(&config.upstreamURL, "url", "https://127.0.0.1:6443", "the url for the kubernetes upstream api service, must be https")
+	flag.StringVar(&config.clientCA, "client-ca", "", "the path to a ca bundle used to authenticate the client certificates")
+	flag.StringVar(&config.tokenAuthFile, "token-auth-file", "", "the path to a csv file of bearer tokens, i.e. token,user,uid,\"group1,group2\"")
+	flag.StringVar(&config.basicAuthFile, "basic-auth-file", "", "the path to a csv file of basic auth credentials, i.e. password,user,uid,\"group1,group2\"")
+	flag.StringVar(&config.oidcIssuerURL, "oidc-issuer-url", "", "the issuer url of the oidc id tokens, enables the oidc authentication")
+	flag.StringVar(&config.oidcClientID, "oidc-client-id", "", "the client id the oidc id tokens must be issued for")
+	flag.StringVar(&config.oidcJWKS, "oidc-jwks", "", "the path or url of the json web key set used to verify the oidc id tokens")
+	flag.StringVar(&config.oidcUsernameClaim, "oidc-username-claim", "sub", "the claim in the oidc id token used as the username")
+	flag.StringVar(&config.oidcGroupsClaim, "oidc-groups-claim", "groups", "the claim in the oidc id token used as the groups")
+	flag.BoolVar(&config.tokenReview, "token-review", false, "resolve the bearer tokens via the token review api of the upstream")
+	flag.DurationVar(&config.tokenReviewTTL, "token-review-ttl", 2*time.Minute, "the time to cache the authenticated token reviews")
+	flag.DurationVar(&config.tokenReviewNegativeTTL, "token-review-negative-ttl", 10*time.Second, "the time to cache the rejected token reviews")
+	flag.BoolVar(&config.anonymousAuth, "anonymous-auth", false, "permit the unauthenticated requests as system:anonymous, rather than rejecting them")
+	flag.StringVar(&config.dockerSocket, "docker-socket", "/var/run/docker.sock", "the path to the docker socket proxied in docker mode")
+	flag.StringVar(&config.dockerNamespace, "docker-namespace", "docker", "the pseudo namespace used to select the policy in docker mode")
+	flag.StringVar(&config.kubeletAllowedPaths, "kubelet-allowed-paths", "/healthz", "a comma separated list of the kubelet paths, supporting wildcards, permitted in kubelet mode besides the streams and logs checked against the policy, the others being refused")
+	flag.StringVar(&config.upstreamURL, "url", "https://127.0.0.1:6443", "the url for the kubernetes upstream api service or kubelet, must be https, a comma separated list balancing between the api services")
+	flag.StringVar(&config.upstreamBalance, "upstream-balance", "round-robin", "the balancing of the requests between the upstreams, round-robin or least-connections")
+	flag.DurationVar(&config.upstreamHealthInterval, "upstream-health-interval", 5*time.Second, "the interval of the /healthz checks of the upstreams, when more than one")
+	flag.DurationVar(&config.upstreamResponseTimeout, "upstream-response-timeout", 0, "the time to wait for the response headers of the upstream, zero disables")
+	flag.DurationVar(&config.upstreamRequestTimeout, "upstream-request-timeout", 0, "the total time permitted to a request to the upstream, the response body included, zero disables")
+	flag.DurationVar(&config.upstreamStreamTimeout, "upstream-stream-timeout", 0, "the total time permitted to a watch or log follow of the upstream, zero disables")
+	flag.IntVar(&config.upstreamFailureThreshold, "upstream-failure-threshold", 0, "the consecutive failures of an upstream opening its circuit, failing the requests fast, zero disables")
+	flag.DurationVar(&config.upstreamBreakerTimeout, "upstream-breaker-timeout", 30*time.Second, "the time the circuit of an upstream is open before a request is permitted through to probe it")
+	flag.StringVar(&config.upstreamCA, "upstream-ca", "", "the path to the ca bundle used to verify the upstream certificate, defaults to the system roots")
+	flag.StringVar(&config.upstreamClientCert, "upstream-client-cert", "", "the path to the client certificate presented to the upstream")
+	flag.StringVar(&config.upstreamClientKey, "upstream-client-key", "", "the path to the client private key presented to the upstream")
+	flag.StringVar(&config.upstreamToken, "upstream-token", "", "the bearer token presented to the upstream")
+	flag.BoolVar(&config.upstreamInsecure, "upstream-insecure", false, "skip the verification of the upstream certificate, not recommended")
+	flag.StringVar(&config.kubeconfig, "kubeconfig", "", "the path to a kubeconfig holding the upstream url and credentials, the options above take precedence")
+	flag.StringVar(&config.kubeContext, "kube-context", "", "the context in the kubeconfig to use, defaults to the current context")
+	flag.BoolVar(&config.impersonate, "impersonate", false, "forward the verified identity of the caller to the upstream via the impersonation headers")
+	flag.Float64Var(&config.rateLimit, "rate-limit", 0, "the requests per second permitted to each user, namespace and verb in proxy mode, zero disables")
+	flag.IntVar(&config.rateLimitBurst, "rate-limit-burst", 0, "the burst of requests permitted to each user, namespace and verb, defaults to the rate")
+	flag.Float64Var(&config.writeRateLimit, "write-rate-limit", 0, "the writes per second to the pods, replication controllers and workloads permitted to each user, namespace and verb, zero disables")
+	flag.IntVar(&config.writeRateLimitBurst, "write-rate-limit-burst", 0, "the burst of writes to the workloads permitted to each user, namespace and verb, defaults to the rate")
+	flag.Int64Var(&config.maxBodySize, "max-body-size", 10<<20, "the maximum size in bytes of the pod, controller and container bodies decoded and validated, larger requests being refused, zero being unlimited")
+	flag.DurationVar(&config.discoveryCacheTTL, "discovery-cache-ttl", 0, "the time the discovery (/api, /apis and /version) responses are cached in proxy mode, zero disables")
+	flag.DurationVar(&config.serverWriteTimeout, "server-write-timeout", 0, "the time permitted to write a response, the watches, log follows and upgraded connections being exempt, zero disables")
+	flag.DurationVar(&config.upgradeIdleTimeout, "upgrade-idle-timeout", 30*time.Minute, "the time an upgraded (exec, attach, port forward) connection can be idle before it's closed, zero disables")
+	flag.DurationVar(&config.upgradeMaxDuration, "upgrade-max-duration", 0, "the maximum duration of an upgraded connection, zero being unlimited")
+	flag.StringVar(&config.sessionsDir, "sessions-dir", "/var/lib/kube-cover/sessions", "the directory the exec and attach sessions selected by the policy are recorded to")
 	flag.StringVar(&config.policyFile, "policy-file", "", "the path to the policy file container authorization security policies")
-	flag.StringVar(&config.bindInterface, "bind", ":6444", "the interface and port for the service to listen on")
+	flag.StringVar(&config.clustersFile, "clusters-file", "", "the path to a file of the clusters routed to by the sni host name or path prefix, each with its own upstream and policy")
+	flag.StringVar(&config.roleFile, "role-file", "", "the path to a role file of the verbs, resources and namespaces permitted to the users and groups")
+	flag.StringVar(&config.shadowPolicyFile, "shadow-policy-file", "", "the path to a shadow policy file, evaluated against every request but never enforced")
+	flag.StringVar(&config.metricsBind, "metrics-bind", "", "the interface and port to expose the prometheus metrics on, disabled if empty")
+	flag.BoolVar(&config.reconcile, "reconcile", false, "whether to watch the pods in the cluster and report those violating the policy")
+	flag.StringVar(&config.reconcileAction, "reconcile-action", "none", "the action taken on non-compliant pods after the grace period, none, delete or scale")
+	flag.DurationVar(&config.reconcileGracePeriod, "reconcile-grace", 5*time.Minute, "the grace period before the reconciler takes action on a non-compliant pod")
+	flag.StringVar(&config.bindInterface, "bind", ":6444", "the interface and port for the service to listen on, or the path of the unix socket in docker mode")
 }
 
 // parseConfig validate the command line options
 func parseConfig() error {
 	flag.Parse()
 
-	if config.certificateFile == "" {
+	if config.certificateFile == "" && config.mode != "docker" {
 		return fmt.Errorf("you have not specified a certificate to use")
 	}
-	if config.privateKeyFile == "" {
+	if config.privateKeyFile == "" && config.mode != "docker" {
 		return fmt.Errorf("you have not specified a private key to use")
 	}
-	if config.upstreamURL == "" {
+	switch config.mode {
+	case "proxy", "admission":
+	case "kubelet":
+		if config.clientCA == "" && config.tokenAuthFile == "" && config.basicAuthFile == "" && config.oidcIssuerURL == "" {
+			return fmt.Errorf("you have not specified a method to authenticate the kubelet callers")
+		}
+	case "docker":
+		if config.dockerSocket == "" {
+			return fmt.Errorf("you have not specified the docker socket")
+		}
+		if config.dockerNamespace == "" {
+			return fmt.Errorf("you have not specified the docker pseudo namespace")
+		}
+	default:
+		return fmt.Errorf("unsupported mode: %s, must be proxy, admission, kubelet or docker", config.mode)
+	}
+	if config.upstreamURL == "" && config.kubeconfig == "" {
 		return fmt.Errorf("you have not specified the upstream kubernetes api url")
 	}
-	if config.policyFile == "" {
+	if config.upstreamBalance != "round-robin" && config.upstreamBalance != "least-connections" {
+		return fmt.Errorf("the upstream balance must be round-robin or least-connections")
+	}
+	if config.upstreamHealthInterval <= 0 {
+		return fmt.Errorf("the upstream health interval must be positive")
+	}
+	if config.upstreamResponseTimeout < 0 || config.upstreamRequestTimeout < 0 || config.upstreamStreamTimeout < 0 {
+		return fmt.Errorf("the upstream timeouts cannot be negative")
+	}
+	if config.upstreamFailureThreshold < 0 {
+		return fmt.Errorf("the upstream failure threshold cannot be negative")
+	}
+	if config.upstreamFailureThreshold > 0 && config.upstreamBreakerTimeout <= 0 {
+		return fmt.Errorf("the upstream breaker timeout must be positive")
+	}
+	if config.oidcIssuerURL != "" && (config.oidcClientID == "" || config.oidcJWKS == "") {
+		return fmt.Errorf("you must specify the oidc client id and jwks with the issuer url")
+	}
+	if (config.upstreamClientCert == "") != (config.upstreamClientKey == "") {
+		return fmt.Errorf("you must specify both the upstream client certificate and private key")
+	}
+	if config.rateLimit < 0 || config.writeRateLimit < 0 || config.rateLimitBurst < 0 || config.writeRateLimitBurst < 0 {
+		return fmt.Errorf("the rate limits cannot be negative")
+	}
+	if config.maxBodySize < 0 {
+		return fmt.Errorf("the maximum body size cannot be negative")
+	}
+	if config.clustersFile != "" && config.mode != "proxy" {
+		return fmt.Errorf("the clusters file is only supported in proxy mode")
+	}
+	if config.policyFile == "" && config.clustersFile == "" {
 		return fmt.Errorf("you have not specified the policy file")
 	}
+	if config.reconcile && config.reconcileGracePeriod < 0 {
+		return fmt.Errorf("the reconcile grace period cannot be negative")
+	}
 
 	return nil
 }
@@ -20,3 +20,9 @@ package main
 const (
 	version = "v0.0.6"
 )
+
+// service is the kube cover service, or the clusters behind it
+type service interface {
+	// start handling the requests
+	Run(address, certFile, privateFile string) error
+}
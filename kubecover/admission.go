@@ -0,0 +1,224 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gambol99/kube-cover/policy"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/auth/user"
+	"k8s.io/kubernetes/pkg/types"
+)
+
+const (
+	// the default api version of the admission review
+	admissionAPIVersion = "admission.k8s.io/v1"
+)
+
+// admissionReview is the review sent by the api server to the webhook
+type admissionReview struct {
+	unversioned.TypeMeta `json:",inline"`
+	// the request being reviewed
+	Request *admissionRequest `json:"request,omitempty"`
+	// the response to the review
+	Response *admissionResponse `json:"response,omitempty"`
+}
+
+// admissionRequest is the attributes of the request being admitted
+type admissionRequest struct {
+	// the identifier of the request
+	UID types.UID `json:"uid"`
+	// the kind of the object
+	Kind admissionKind `json:"kind"`
+	// the namespace of the object
+	Namespace string `json:"namespace,omitempty"`
+	// the name of the object
+	Name string `json:"name,omitempty"`
+	// the operation being performed, CREATE, UPDATE, DELETE or CONNECT
+	Operation string `json:"operation"`
+	// the user performing the request
+	UserInfo admissionUserInfo `json:"userInfo"`
+	// the object being admitted
+	Object json.RawMessage `json:"object,omitempty"`
+}
+
+// admissionKind is the group, version and kind of the object
+type admissionKind struct {
+	// the api group
+	Group string `json:"group"`
+	// the api version
+	Version string `json:"version"`
+	// the kind of object
+	Kind string `json:"kind"`
+}
+
+// admissionUserInfo is the user performing the request
+type admissionUserInfo struct {
+	// the name of the user
+	Username string `json:"username"`
+	// the uid of the user
+	UID string `json:"uid"`
+	// the groups the user is a member of
+	Groups []string `json:"groups"`
+}
+
+// admissionResponse is the decision of the webhook
+type admissionResponse struct {
+	// the identifier of the request
+	UID types.UID `json:"uid"`
+	// whether the request is allowed
+	Allowed bool `json:"allowed"`
+	// the reason for the denial
+	Result *unversioned.Status `json:"status,omitempty"`
+}
+
+// podTemplateObject is any controller which embeds a pod template in the spec
+type podTemplateObject struct {
+	api.ObjectMeta `json:"metadata,omitempty"`
+	// the spec of the controller
+	Spec struct {
+		Template api.PodTemplateSpec `json:"template"`
+	} `json:"spec"`
+}
+
+// cronJobObject is a cron job embedding a job template
+type cronJobObject struct {
+	api.ObjectMeta `json:"metadata,omitempty"`
+	// the spec of the cron job
+	Spec struct {
+		JobTemplate struct {
+			Spec struct {
+				Template api.PodTemplateSpec `json:"template"`
+			} `json:"spec"`
+		} `json:"jobTemplate"`
+	} `json:"spec"`
+}
+
+// handleAdmission handles the admission reviews from the api server, the mutate endpoint shares
+// the same decision as no mutations are performed
+func (r *KubeCover) handleAdmission(cx *gin.Context) {
+	review := new(admissionReview)
+	if err := json.NewDecoder(cx.Request.Body).Decode(review); err != nil {
+		glog.Errorf("unable to decode the admission review, error: %s", err)
+		cx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		glog.Errorf("the admission review has no request")
+		cx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	response := &admissionResponse{UID: review.Request.UID, Allowed: true}
+	if err := r.admit(review.Request); err != nil {
+		glog.Errorf("denying admission, namespace: %s, kind: %s, name: %s, user: %s, failure: %s violation",
+			review.Request.Namespace, review.Request.Kind.Kind, review.Request.Name, review.Request.UserInfo.Username, err)
+
+		message := "security policy violation, reason: " + err.Error()
+		response.Allowed = false
+		response.Result = &unversioned.Status{
+			Status:  unversioned.StatusFailure,
+			Message: message,
+			Reason:  unversioned.StatusReasonForbidden,
+			Code:    http.StatusForbidden,
+			Details: &unversioned.StatusDetails{
+				Name:   review.Request.Name,
+				Kind:   review.Request.Kind.Kind,
+				Causes: []unversioned.StatusCause{{Type: unversioned.CauseTypeFieldValueNotSupported, Message: message}},
+			},
+		}
+	}
+
+	apiVersion := review.APIVersion
+	if apiVersion == "" {
+		apiVersion = admissionAPIVersion
+	}
+
+	cx.JSON(http.StatusOK, &admissionReview{
+		TypeMeta: unversioned.TypeMeta{Kind: "AdmissionReview", APIVersion: apiVersion},
+		Response: response,
+	})
+}
+
+// admit extracts the pod spec from the object and validates it against the policy
+func (r *KubeCover) admit(request *admissionRequest) error {
+	switch request.Operation {
+	case "CREATE", "UPDATE":
+	default:
+		return nil
+	}
+
+	name, spec, err := admissionPodSpec(request.Kind.Kind, request.Object)
+	if err != nil {
+		return fmt.Errorf("unable to decode the object, %s", err)
+	}
+	if spec == nil {
+		glog.V(10).Infof("ignoring the admission of kind: %s, no pod template", request.Kind.Kind)
+		return nil
+	}
+	if request.Name != "" {
+		name = request.Name
+	}
+
+	context := &policy.PolicyContext{
+		Namespace: request.Namespace,
+		Cluster:   r.config.ClusterName,
+		User: &user.DefaultInfo{
+			Name:   request.UserInfo.Username,
+			UID:    request.UserInfo.UID,
+			Groups: request.UserInfo.Groups,
+		},
+	}
+
+	glog.V(10).Infof("authorizating admission, namespace: %s, kind: %s, name: %s", context.Namespace, request.Kind.Kind, name)
+
+	return r.authorize(context, request.Kind.Kind, name, spec)
+}
+
+// admissionPodSpec extracts the name and pod spec from the kinds which embed a pod
+func admissionPodSpec(kind string, object json.RawMessage) (string, *api.PodSpec, error) {
+	switch kind {
+	case "Pod":
+		pod := new(api.Pod)
+		if err := json.Unmarshal(object, pod); err != nil {
+			return "", nil, err
+		}
+		return pod.Name, &pod.Spec, nil
+	case "ReplicationController", "ReplicaSet", "Deployment", "DaemonSet", "StatefulSet", "Job":
+		controller := new(podTemplateObject)
+		if err := json.Unmarshal(object, controller); err != nil {
+			return "", nil, err
+		}
+		return controller.Name, &controller.Spec.Template.Spec, nil
+	case "CronJob":
+		cron := new(cronJobObject)
+		if err := json.Unmarshal(object, cron); err != nil {
+			return "", nil, err
+		}
+		return cron.Name, &cron.Spec.JobTemplate.Spec.Template.Spec, nil
+	}
+
+	return "", nil, nil
+}
@@ -0,0 +1,103 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gambol99/kube-cover/policy"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+)
+
+// apiProxyHandler validates the requests proxied by the apiserver to the nodes, services and pods
+func (r *KubeCover) apiProxyHandler() gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		target, namespace, name, port, found := apiProxyTarget(cx.Request.URL.Path)
+		if !found {
+			return
+		}
+		glog.V(10).Infof("authorizating the proxy to %s: %s, port: %s, namespace: %s", target, name, port, namespace)
+
+		context := &policy.PolicyContext{Namespace: namespace, Cluster: r.config.ClusterName, User: r.identity(cx)}
+		if err := r.acl.AuthorizedProxy(context, target, name, port); err != nil {
+			r.unauthorizedRequest(cx, fmt.Sprintf("%s %s", cx.Request.Method, cx.Request.URL.Path), err.Error())
+			return
+		}
+	}
+}
+
+// apiProxyTarget extracts the node, service or pod being proxied to from the path, i.e. /api/v1/nodes/<node>/proxy,
+// /api/v1/namespaces/<namespace>/services/<service>/proxy, /api/v1/namespaces/<namespace>/pods/<pod>/proxy and the
+// deprecated /api/v1/proxy/nodes/<node> and /api/v1/proxy/namespaces/<namespace>/(services|pods)/<name>
+func apiProxyTarget(uri string) (policy.ProxyTarget, string, string, string, bool) {
+	var parts []string
+	for _, x := range strings.Split(uri, "/") {
+		if x != "" {
+			parts = append(parts, x)
+		}
+	}
+	if len(parts) < 2 || parts[0] != "api" {
+		return "", "", "", "", false
+	}
+	parts = parts[2:]
+
+	// step: strip the deprecated proxy prefix, else check for the proxy subresource
+	if len(parts) > 0 && parts[0] == "proxy" {
+		parts = parts[1:]
+	} else {
+		switch {
+		case len(parts) >= 3 && parts[0] == "nodes" && parts[2] == "proxy":
+		case len(parts) >= 5 && parts[0] == "namespaces" && (parts[2] == "services" || parts[2] == "pods") && parts[4] == "proxy":
+		default:
+			return "", "", "", "", false
+		}
+	}
+
+	switch {
+	case len(parts) >= 2 && parts[0] == "nodes":
+		name, port := splitProxyName(parts[1])
+		return policy.ProxyNode, "", name, port, true
+	case len(parts) >= 4 && parts[0] == "namespaces" && parts[2] == "services":
+		name, port := splitProxyName(parts[3])
+		return policy.ProxyService, parts[1], name, port, true
+	case len(parts) >= 4 && parts[0] == "namespaces" && parts[2] == "pods":
+		name, port := splitProxyName(parts[3])
+		return policy.ProxyPod, parts[1], name, port, true
+	}
+
+	return "", "", "", "", false
+}
+
+// splitProxyName splits the [scheme:]name[:port] of the proxy target
+func splitProxyName(value string) (string, string) {
+	parts := strings.Split(value, ":")
+	switch len(parts) {
+	case 3:
+		return parts[1], parts[2]
+	case 2:
+		if parts[0] == "http" || parts[0] == "https" {
+			return parts[1], ""
+		}
+		return parts[0], parts[1]
+	}
+
+	return value, ""
+}
@@ -0,0 +1,58 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"testing"
+
+	"github.com/gambol99/kube-cover/policy"
+)
+
+func TestAPIProxyTarget(t *testing.T) {
+	cases := []struct {
+		uri       string
+		target    policy.ProxyTarget
+		namespace string
+		name      string
+		port      string
+		found     bool
+	}{
+		{uri: "/api/v1/nodes/node1/proxy/stats", target: policy.ProxyNode, name: "node1", found: true},
+		{uri: "/api/v1/nodes/node1:10250/proxy/", target: policy.ProxyNode, name: "node1", port: "10250", found: true},
+		{uri: "/api/v1/proxy/nodes/node1/logs/", target: policy.ProxyNode, name: "node1", found: true},
+		{uri: "/api/v1/namespaces/kube-system/services/https:dashboard:443/proxy/", target: policy.ProxyService, namespace: "kube-system", name: "dashboard", port: "443", found: true},
+		{uri: "/api/v1/namespaces/default/services/grafana/proxy", target: policy.ProxyService, namespace: "default", name: "grafana", found: true},
+		{uri: "/api/v1/proxy/namespaces/default/services/grafana:80/", target: policy.ProxyService, namespace: "default", name: "grafana", port: "80", found: true},
+		{uri: "/api/v1/namespaces/default/pods/web-1/proxy/metrics", target: policy.ProxyPod, namespace: "default", name: "web-1", found: true},
+		{uri: "/api/v1/namespaces/default/pods/http:web-1:8080/proxy/", target: policy.ProxyPod, namespace: "default", name: "web-1", port: "8080", found: true},
+		{uri: "/api/v1/proxy/namespaces/default/pods/web-1:8080/admin", target: policy.ProxyPod, namespace: "default", name: "web-1", port: "8080", found: true},
+		{uri: "/api/v1/namespaces/default/pods/web-1"},
+		{uri: "/api/v1/namespaces/default/pods/web-1/log"},
+		{uri: "/api/v1/namespaces/default/services/grafana"},
+		{uri: "/api/v1/nodes/node1"},
+		{uri: "/apis/apps/v1/namespaces/default/deployments"},
+		{uri: "/"},
+	}
+	for _, c := range cases {
+		target, namespace, name, port, found := apiProxyTarget(c.uri)
+		if found != c.found || target != c.target || namespace != c.namespace || name != c.name || port != c.port {
+			t.Errorf("uri: %s, expected: %s %s %s %s %t, got: %s %s %s %s %t", c.uri,
+				c.target, c.namespace, c.name, c.port, c.found, target, namespace, name, port, found)
+		}
+	}
+}
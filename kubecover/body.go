@@ -0,0 +1,231 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// the kind of the pod manifests
+	manifestPod = "pod"
+	// the kind of the replication controller manifests
+	manifestReplicationController = "replicationcontroller"
+)
+
+// the largest buffer returned to the pool, the larger ones being left to the collector
+const maxPooledBodyBuffer = 1 << 20
+
+// errBodyTooLarge is returned when the request body exceeds the maximum size
+var errBodyTooLarge = errors.New("the request body exceeds the maximum size")
+
+// bodyBuffers is the pool of buffers the request bodies are read into
+var bodyBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// bufferedBody replays the buffered request body to the upstream, returning the buffer to the
+// pool once the transport has closed it
+type bufferedBody struct {
+	*bytes.Reader
+	// the buffer holding the body
+	buffer *bytes.Buffer
+	// ensures the buffer is released once
+	once sync.Once
+}
+
+// Close releases the buffer back to the pool
+func (r *bufferedBody) Close() error {
+	r.once.Do(func() { releaseBodyBuffer(r.buffer) })
+	return nil
+}
+
+// objectName holds the name of an object from its metadata
+type objectName struct {
+	Name string `json:"name"`
+}
+
+// podManifest holds the fields of a pod or pod template inspected by the policies, the remainder
+// of the object being skipped by the decoder
+type podManifest struct {
+	Metadata objectName  `json:"metadata"`
+	Spec     api.PodSpec `json:"spec"`
+}
+
+// controllerManifest holds the fields of a replication controller inspected by the policies
+type controllerManifest struct {
+	Metadata objectName `json:"metadata"`
+	Spec     struct {
+		Template *podManifest `json:"template"`
+	} `json:"spec"`
+}
+
+// readBody reads the request body into a pooled buffer of at most the maximum body size, the
+// body being replaced by a reader of the same buffer for forwarding to the upstream
+func (r *KubeCover) readBody(req *http.Request) ([]byte, error) {
+	limit := r.config.MaxBodySize
+	if limit > 0 && req.ContentLength > limit {
+		return nil, errBodyTooLarge
+	}
+
+	buffer := bodyBuffers.Get().(*bytes.Buffer)
+	if req.ContentLength > 0 {
+		buffer.Grow(int(req.ContentLength))
+	}
+	reader := io.Reader(req.Body)
+	if limit > 0 {
+		reader = io.LimitReader(req.Body, limit+1)
+	}
+	if _, err := buffer.ReadFrom(reader); err != nil {
+		releaseBodyBuffer(buffer)
+		return nil, err
+	}
+	if limit > 0 && int64(buffer.Len()) > limit {
+		releaseBodyBuffer(buffer)
+		return nil, errBodyTooLarge
+	}
+
+	content := buffer.Bytes()
+	req.Body = &bufferedBody{Reader: bytes.NewReader(content), buffer: buffer}
+	req.ContentLength = int64(len(content))
+
+	return content, nil
+}
+
+// decodeInput decodes the json payload
+func (r *KubeCover) decodeInput(req *http.Request, data interface{}) ([]byte, error) {
+	content, err := r.readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: decode the json
+	if err := json.NewDecoder(bytes.NewReader(content)).Decode(data); err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// decodeManifests decodes the pod specs of the object in the body, calling the handler with each; the
+// pod spec is selected by the kind of the route, i.e. the spec of a pod or the template of a controller.
+// When the route accepts lists and the kind of the body is a list, the items are instead decoded one at
+// a time, rather than decoding the list as a whole
+func decodeManifests(content []byte, kind string, lists, patch bool, handler func(string, *api.PodSpec) error) error {
+	// step: the kind is read ahead as it may follow the items
+	var header struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(content, &header); err != nil {
+		return err
+	}
+	if lists && strings.HasSuffix(header.Kind, "List") {
+		return decodeItems(json.NewDecoder(bytes.NewReader(content)), kind, handler)
+	}
+
+	return decodeManifest(json.NewDecoder(bytes.NewReader(content)), kind, patch, handler)
+}
+
+// decodeManifest decodes the next object from the decoder, calling the handler with the pod spec
+func decodeManifest(decoder *json.Decoder, kind string, patch bool, handler func(string, *api.PodSpec) error) error {
+	switch kind {
+	case manifestReplicationController:
+		controller := new(controllerManifest)
+		if err := decoder.Decode(controller); err != nil {
+			return err
+		}
+		if controller.Spec.Template == nil {
+			// step: a patch without the template leaves the template unchanged
+			if patch {
+				return nil
+			}
+			return fmt.Errorf("the replication controller has no pod template")
+		}
+		return handler(controller.Metadata.Name, &controller.Spec.Template.Spec)
+	case manifestPod:
+		pod := new(podManifest)
+		if err := decoder.Decode(pod); err != nil {
+			return err
+		}
+		return handler(pod.Metadata.Name, &pod.Spec)
+	}
+
+	return fmt.Errorf("unsupported manifest kind: %s", kind)
+}
+
+// decodeItems decodes the items of a list one at a time, skipping the other fields of the list
+func decodeItems(decoder *json.Decoder, kind string, handler func(string, *api.PodSpec) error) error {
+	if token, err := decoder.Token(); err != nil {
+		return err
+	} else if token != json.Delim('{') {
+		return fmt.Errorf("the request body is not a json object")
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if token != "items" {
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if token, err = decoder.Token(); err != nil {
+			return err
+		}
+		if token == nil {
+			continue
+		}
+		if token != json.Delim('[') {
+			return fmt.Errorf("the items of the list are not an array")
+		}
+		for decoder.More() {
+			if err := decodeManifest(decoder, kind, false, handler); err != nil {
+				return err
+			}
+		}
+		// step: consume the end of the array
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseBodyBuffer returns the buffer to the pool
+func releaseBodyBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() > maxPooledBodyBuffer {
+		return
+	}
+	buffer.Reset()
+	bodyBuffers.Put(buffer)
+}
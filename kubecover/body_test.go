@@ -0,0 +1,126 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	privilegedSpec   = `{"containers":[{"name":"c","image":"nginx","securityContext":{"privileged":true}}]}`
+	unprivilegedSpec = `{"containers":[{"name":"c","image":"nginx"}]}`
+)
+
+func TestDecodeManifests(t *testing.T) {
+	cases := []struct {
+		name    string
+		kind    string
+		lists   bool
+		patch   bool
+		body    string
+		checked []string
+		fails   bool
+	}{
+		{
+			name:    "pod",
+			kind:    manifestPod,
+			body:    `{"kind":"Pod","metadata":{"name":"a"},"spec":` + privilegedSpec + `}`,
+			checked: []string{"a:privileged"},
+		},
+		{
+			name:    "pod with a template in the spec",
+			kind:    manifestPod,
+			body:    `{"kind":"Pod","metadata":{"name":"a"},"spec":{"template":{"spec":{}},"containers":[{"name":"c","securityContext":{"privileged":true}}]}}`,
+			checked: []string{"a:privileged"},
+		},
+		{
+			name:    "pod with items",
+			kind:    manifestPod,
+			lists:   true,
+			body:    `{"kind":"Pod","items":[],"metadata":{"name":"a"},"spec":` + privilegedSpec + `}`,
+			checked: []string{"a:privileged"},
+		},
+		{
+			name:    "list on a route without lists",
+			kind:    manifestPod,
+			body:    `{"kind":"List","items":[{"metadata":{"name":"a"},"spec":` + unprivilegedSpec + `}],"spec":` + privilegedSpec + `}`,
+			checked: []string{":privileged"},
+		},
+		{
+			name:    "list",
+			kind:    manifestPod,
+			lists:   true,
+			body:    `{"items":[{"metadata":{"name":"a"},"spec":` + unprivilegedSpec + `},{"metadata":{"name":"b"},"spec":` + privilegedSpec + `}],"kind":"List"}`,
+			checked: []string{"a:", "b:privileged"},
+		},
+		{
+			name:  "list with invalid items",
+			kind:  manifestPod,
+			lists: true,
+			body:  `{"kind":"PodList","items":{}}`,
+			fails: true,
+		},
+		{
+			name:    "controller",
+			kind:    manifestReplicationController,
+			body:    `{"kind":"ReplicationController","metadata":{"name":"rc"},"spec":{"replicas":1,"template":{"spec":` + privilegedSpec + `}}}`,
+			checked: []string{"rc:privileged"},
+		},
+		{
+			name:  "controller without a template",
+			kind:  manifestReplicationController,
+			body:  `{"kind":"ReplicationController","metadata":{"name":"rc"},"spec":` + privilegedSpec + `}`,
+			fails: true,
+		},
+		{
+			name:  "controller patch without a template",
+			kind:  manifestReplicationController,
+			patch: true,
+			body:  `{"metadata":{"labels":{"a":"b"}}}`,
+		},
+		{
+			name:  "invalid json",
+			kind:  manifestPod,
+			body:  `{"spec":`,
+			fails: true,
+		},
+	}
+	for _, c := range cases {
+		var checked []string
+		err := decodeManifests([]byte(c.body), c.kind, c.lists, c.patch, func(name string, spec *api.PodSpec) error {
+			privileged := ""
+			for _, x := range spec.Containers {
+				if x.SecurityContext != nil && x.SecurityContext.Privileged != nil && *x.SecurityContext.Privileged {
+					privileged = "privileged"
+				}
+			}
+			checked = append(checked, name+":"+privileged)
+			return nil
+		})
+		if c.fails != (err != nil) {
+			t.Errorf("case: %s, expected failure: %t, error: %v", c.name, c.fails, err)
+			continue
+		}
+		if !reflect.DeepEqual(checked, c.checked) {
+			t.Errorf("case: %s, expected: %v, checked: %v", c.name, c.checked, checked)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// the states of the circuit breaker of an upstream
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// errCircuitOpen is returned when the circuit breakers of every upstream are open
+var errCircuitOpen = errors.New("the circuit breakers of the upstreams are open")
+
+// circuitBreaker fails the requests to an upstream fast after a number of consecutive failures,
+// permitting a single probe request through once the timeout has passed to test the recovery
+type circuitBreaker struct {
+	sync.Mutex
+	// the name of the upstream
+	upstream string
+	// the consecutive failures which open the circuit, zero disables
+	threshold int
+	// the time the circuit is open before probing the upstream
+	timeout time.Duration
+	// the number of consecutive failures
+	failures int
+	// the state of the circuit
+	state int
+	// the time the circuit was opened
+	opened time.Time
+}
+
+// available checks if a request to the upstream would be permitted
+func (r *circuitBreaker) available() bool {
+	if r.threshold <= 0 {
+		return true
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	switch r.state {
+	case circuitOpen:
+		return time.Since(r.opened) >= r.timeout
+	case circuitHalfOpen:
+		return false
+	}
+
+	return true
+}
+
+// allow claims a request to the upstream, the first request once the timeout has passed
+// becoming the probe of the half open circuit
+func (r *circuitBreaker) allow() bool {
+	if r.threshold <= 0 {
+		return true
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	switch r.state {
+	case circuitOpen:
+		if time.Since(r.opened) < r.timeout {
+			return false
+		}
+		glog.Infof("probing the upstream: %s, the circuit is half open", r.upstream)
+		r.state = circuitHalfOpen
+	case circuitHalfOpen:
+		return false
+	}
+
+	return true
+}
+
+// success records a successful request, closing the circuit
+func (r *circuitBreaker) success() {
+	if r.threshold <= 0 {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	if r.state != circuitClosed {
+		glog.Infof("the upstream: %s has recovered, closing the circuit", r.upstream)
+		upstreamCircuitGauge.WithLabelValues(r.upstream).Set(0)
+	}
+	r.state = circuitClosed
+	r.failures = 0
+}
+
+// failure records a failed request, opening the circuit once the threshold is reached or
+// should the probe of the half open circuit fail
+func (r *circuitBreaker) failure(reason error) {
+	if r.threshold <= 0 {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	r.failures++
+	if r.state == circuitHalfOpen || (r.state == circuitClosed && r.failures >= r.threshold) {
+		glog.Warningf("opening the circuit of the upstream: %s for %s after %d consecutive failures, error: %s",
+			r.upstream, r.timeout, r.failures, reason)
+		upstreamCircuitGauge.WithLabelValues(r.upstream).Set(1)
+		r.state = circuitOpen
+		r.opened = time.Now()
+	}
+}
+
+// abandon records a request abandoned by the client, the upstream being probed again by the
+// next request should it have been the probe
+func (r *circuitBreaker) abandon() {
+	if r.threshold <= 0 {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	if r.state == circuitHalfOpen {
+		r.state = circuitOpen
+	}
+}
@@ -0,0 +1,149 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failure := errors.New("connection refused")
+	cases := []struct {
+		name      string
+		threshold int
+		steps     func(*circuitBreaker)
+		available bool
+		allowed   bool
+	}{
+		{
+			name:      "closed",
+			threshold: 3,
+			steps:     func(r *circuitBreaker) {},
+			available: true,
+			allowed:   true,
+		},
+		{
+			name:      "below the threshold",
+			threshold: 3,
+			steps: func(r *circuitBreaker) {
+				r.failure(failure)
+				r.failure(failure)
+			},
+			available: true,
+			allowed:   true,
+		},
+		{
+			name:      "opened by the threshold",
+			threshold: 3,
+			steps: func(r *circuitBreaker) {
+				r.failure(failure)
+				r.failure(failure)
+				r.failure(failure)
+			},
+		},
+		{
+			name:      "reset by a success",
+			threshold: 3,
+			steps: func(r *circuitBreaker) {
+				r.failure(failure)
+				r.failure(failure)
+				r.success()
+				r.failure(failure)
+				r.failure(failure)
+			},
+			available: true,
+			allowed:   true,
+		},
+		{
+			name:      "probed once the timeout has passed",
+			threshold: 1,
+			steps: func(r *circuitBreaker) {
+				r.failure(failure)
+				r.opened = time.Now().Add(-time.Minute)
+			},
+			available: true,
+			allowed:   true,
+		},
+		{
+			name:      "a single probe when half open",
+			threshold: 1,
+			steps: func(r *circuitBreaker) {
+				r.failure(failure)
+				r.opened = time.Now().Add(-time.Minute)
+				r.allow()
+			},
+		},
+		{
+			name:      "reopened by a failed probe",
+			threshold: 1,
+			steps: func(r *circuitBreaker) {
+				r.failure(failure)
+				r.opened = time.Now().Add(-time.Minute)
+				r.allow()
+				r.failure(failure)
+			},
+		},
+		{
+			name:      "closed by a successful probe",
+			threshold: 1,
+			steps: func(r *circuitBreaker) {
+				r.failure(failure)
+				r.opened = time.Now().Add(-time.Minute)
+				r.allow()
+				r.success()
+			},
+			available: true,
+			allowed:   true,
+		},
+		{
+			name:      "probed again once the probe is abandoned",
+			threshold: 1,
+			steps: func(r *circuitBreaker) {
+				r.failure(failure)
+				r.opened = time.Now().Add(-time.Minute)
+				r.allow()
+				r.abandon()
+			},
+			available: true,
+			allowed:   true,
+		},
+		{
+			name:      "disabled",
+			threshold: 0,
+			steps: func(r *circuitBreaker) {
+				for i := 0; i < 10; i++ {
+					r.failure(failure)
+				}
+			},
+			available: true,
+			allowed:   true,
+		},
+	}
+	for _, c := range cases {
+		breaker := &circuitBreaker{upstream: "https://127.0.0.1:6443", threshold: c.threshold, timeout: 30 * time.Second}
+		c.steps(breaker)
+		if available := breaker.available(); available != c.available {
+			t.Errorf("case %s: expected available: %t, got: %t", c.name, c.available, available)
+		}
+		if allowed := breaker.allow(); allowed != c.allowed {
+			t.Errorf("case %s: expected allowed: %t, got: %t", c.name, c.allowed, allowed)
+		}
+	}
+}
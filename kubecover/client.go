@@ -0,0 +1,127 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/gambol99/kube-cover/auth"
+)
+
+// upstreamTokenReviewer submits the token reviews to the upstream api
+type upstreamTokenReviewer struct {
+	// the kube cover service
+	cover *KubeCover
+}
+
+// Review posts the token review to the upstream
+func (r *upstreamTokenReviewer) Review(review *auth.TokenReview) (*auth.TokenReview, error) {
+	result := new(auth.TokenReview)
+	if err := r.cover.upstreamRequest("POST", "/apis/authentication.k8s.io/v1/tokenreviews", "application/json", review, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// newUpstreamRequest creates a request against the upstream api, encoding the body if any
+func (r *KubeCover) newUpstreamRequest(method, uri, contentType string, body interface{}) (*http.Request, error) {
+	location, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(content)
+	}
+
+	request, err := http.NewRequest(method, r.upstream.ResolveReference(location).String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	r.credentials.setCredentials(request)
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	return request, nil
+}
+
+// upstreamRequest performs a request against the upstream api and decodes the result if required
+func (r *KubeCover) upstreamRequest(method, uri, contentType string, body, result interface{}) error {
+	request, err := r.newUpstreamRequest(method, uri, contentType, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := upstreamError(resp); err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// upstreamStream performs a get against the upstream api and returns the response body, i.e. a watch
+func (r *KubeCover) upstreamStream(uri string) (io.ReadCloser, error) {
+	request, err := r.newUpstreamRequest("GET", uri, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := upstreamError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// upstreamError checks the status code of the upstream response and extracts the failure
+func upstreamError(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+	content, _ := ioutil.ReadAll(resp.Body)
+
+	return fmt.Errorf("upstream responded with %d, %s", resp.StatusCode, bytes.TrimSpace(content))
+}
@@ -0,0 +1,299 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gambol99/kube-cover/utils"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+// ClusterConfig is a cluster behind the proxy, selected by the sni host name or path prefix
+type ClusterConfig struct {
+	// Name is the name of the cluster, matched by the clusters of the policies
+	Name string `json:"name" yaml:"name"`
+	// Hostnames is the sni host names routed to the cluster
+	Hostnames []string `json:"hostnames" yaml:"hostnames"`
+	// PathPrefix is the path prefix routed to the cluster, removed from the requests, i.e. /clusters/prod
+	PathPrefix string `json:"pathPrefix" yaml:"pathprefix"`
+	// TLSCert is the path to the certificate served for the host names
+	TLSCert string `json:"tlsCert" yaml:"tlscert"`
+	// TLSKey is the path to the private key of the certificate
+	TLSKey string `json:"tlsKey" yaml:"tlskey"`
+	// URL is the url of the api, a comma separated list balancing between the api services
+	URL string `json:"url" yaml:"url"`
+	// UpstreamCA is the path to the ca bundle used to verify the api
+	UpstreamCA string `json:"upstreamCA" yaml:"upstreamca"`
+	// UpstreamClientCert is the path to the client certificate presented to the api
+	UpstreamClientCert string `json:"upstreamClientCert" yaml:"upstreamclientcert"`
+	// UpstreamClientKey is the path to the client private key presented to the api
+	UpstreamClientKey string `json:"upstreamClientKey" yaml:"upstreamclientkey"`
+	// UpstreamToken is the bearer token presented to the api
+	UpstreamToken string `json:"upstreamToken" yaml:"upstreamtoken"`
+	// UpstreamInsecure skips the verification of the api certificate
+	UpstreamInsecure bool `json:"upstreamInsecure" yaml:"upstreaminsecure"`
+	// Kubeconfig is the path to a kubeconfig holding the url and credentials of the api
+	Kubeconfig string `json:"kubeconfig" yaml:"kubeconfig"`
+	// KubeContext is the context in the kubeconfig
+	KubeContext string `json:"kubeContext" yaml:"kubecontext"`
+	// PolicyFile is the path to the policy file of the cluster, defaults to the -policy-file
+	PolicyFile string `json:"policyFile" yaml:"policyfile"`
+	// RoleFile is the path to the role file of the cluster, defaults to the -role-file
+	RoleFile string `json:"roleFile" yaml:"rolefile"`
+	// ShadowPolicyFile is the path to the shadow policy file of the cluster, defaults to the -shadow-policy-file
+	ShadowPolicyFile string `json:"shadowPolicyFile" yaml:"shadowpolicyfile"`
+}
+
+// ClusterList is a list of the clusters behind the proxy
+type ClusterList struct {
+	// Clusters is the clusters
+	Clusters []*ClusterConfig `json:"clusters" yaml:"clusters"`
+}
+
+// Clusters routes the requests to the kube cover of each cluster, sharing the listener and metrics
+type Clusters struct {
+	// the service configuration
+	config *Config
+	// the routes to the clusters
+	routes []*clusterRoute
+}
+
+// clusterRoute is the route to a cluster
+type clusterRoute struct {
+	// the configuration of the cluster
+	cluster *ClusterConfig
+	// the certificate served for the host names, if any
+	certificate *tls.Certificate
+	// the kube cover of the cluster
+	cover *KubeCover
+	// the handler of the requests to the cluster
+	handler http.Handler
+}
+
+// NewClusters creates a kube cover for each of the clusters in the file, the clusters inheriting the
+// configuration other than the upstream, which each cluster must specify
+func NewClusters(config *Config, filename string) (*Clusters, error) {
+	if config.Mode != ModeProxy && config.Mode != "" {
+		return nil, fmt.Errorf("the clusters are only supported in proxy mode")
+	}
+	list, err := loadClusters(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	service := &Clusters{config: config}
+	for _, x := range list.Clusters {
+		glog.Infof("creating the cluster: %s, hostnames: %v, path prefix: %s", x.Name, x.Hostnames, x.PathPrefix)
+
+		options := *config
+		options.ClusterName = x.Name
+		options.MetricsBind = ""
+		options.UpstreamURL = x.URL
+		options.UpstreamCA = x.UpstreamCA
+		options.UpstreamClientCert = x.UpstreamClientCert
+		options.UpstreamClientKey = x.UpstreamClientKey
+		options.UpstreamToken = x.UpstreamToken
+		options.UpstreamInsecure = x.UpstreamInsecure
+		options.Kubeconfig = x.Kubeconfig
+		options.KubeContext = x.KubeContext
+		if x.PolicyFile != "" {
+			options.PolicyFile = x.PolicyFile
+		}
+		if x.RoleFile != "" {
+			options.RoleFile = x.RoleFile
+		}
+		if x.ShadowPolicyFile != "" {
+			options.ShadowPolicyFile = x.ShadowPolicyFile
+		}
+
+		cover, err := NewCover(&options)
+		if err != nil {
+			return nil, fmt.Errorf("cluster: %s, %s", x.Name, err)
+		}
+		route := &clusterRoute{cluster: x, cover: cover, handler: streamingHandler(cover.engine)}
+		if x.TLSCert != "" {
+			certificate, err := tls.LoadX509KeyPair(x.TLSCert, x.TLSKey)
+			if err != nil {
+				return nil, fmt.Errorf("cluster: %s, invalid certificate, %s", x.Name, err)
+			}
+			route.certificate = &certificate
+		}
+		service.routes = append(service.routes, route)
+	}
+
+	return service, nil
+}
+
+// Run starts the background tasks of the clusters and begins serving content
+func (r *Clusters) Run(address, certFile, privateFile string) error {
+	serveMetrics(r.config.MetricsBind)
+	for _, x := range r.routes {
+		x.cover.start()
+	}
+
+	server, err := newServer(r.config, r, address)
+	if err != nil {
+		return err
+	}
+	server.TLSConfig.GetCertificate = r.getCertificate
+
+	return server.ListenAndServeTLS(certFile, privateFile)
+}
+
+// ServeHTTP routes the request to the cluster
+func (r *Clusters) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	route, path := r.route(req)
+	if route == nil {
+		writeStatus(w, http.StatusNotFound, unversioned.StatusReasonNotFound, "no cluster is routed for the request")
+		return
+	}
+	glog.V(10).Infof("routing the request to the cluster: %s, uri: %s", route.cluster.Name, req.URL.Path)
+
+	if path != req.URL.Path {
+		req.URL.Path, req.URL.RawPath = path, ""
+		req.RequestURI = req.URL.RequestURI()
+	}
+
+	route.handler.ServeHTTP(w, req)
+}
+
+// route finds the cluster of the request by the sni host name, falling back to the host header, then
+// the longest path prefix, returning the path within the cluster
+func (r *Clusters) route(req *http.Request) (*clusterRoute, string) {
+	hostname := req.Host
+	if req.TLS != nil && req.TLS.ServerName != "" {
+		hostname = req.TLS.ServerName
+	} else if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	for _, x := range r.routes {
+		if utils.ContainedIn(strings.ToLower(hostname), x.cluster.Hostnames) {
+			return x, req.URL.Path
+		}
+	}
+
+	var found *clusterRoute
+	for _, x := range r.routes {
+		prefix := x.cluster.PathPrefix
+		if prefix == "" || (req.URL.Path != prefix && !strings.HasPrefix(req.URL.Path, prefix+"/")) {
+			continue
+		}
+		if found == nil || len(prefix) > len(found.cluster.PathPrefix) {
+			found = x
+		}
+	}
+	if found == nil {
+		return nil, ""
+	}
+	path := strings.TrimPrefix(req.URL.Path, found.cluster.PathPrefix)
+	if path == "" {
+		path = "/"
+	}
+
+	return found, path
+}
+
+// getCertificate selects the certificate of the cluster by the sni host name, nil falling back to
+// the certificate of the service
+func (r *Clusters) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	for _, x := range r.routes {
+		if x.certificate != nil && utils.ContainedIn(strings.ToLower(hello.ServerName), x.cluster.Hostnames) {
+			return x.certificate, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// loadClusters reads and validates the clusters file
+func loadClusters(filename string) (*ClusterList, error) {
+	if !utils.FileExists(filename) {
+		return nil, fmt.Errorf("file %s does not exist", filename)
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	list := new(ClusterList)
+	switch filepath.Ext(filename) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(content, list)
+	case ".json":
+		err = json.Unmarshal(content, list)
+	default:
+		return nil, fmt.Errorf("unsupported extension and clusters file format")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Clusters) <= 0 {
+		return nil, fmt.Errorf("no clusters found in the file: %s", filename)
+	}
+
+	names := make(map[string]bool)
+	for i, x := range list.Clusters {
+		if err := x.isValid(); err != nil {
+			return nil, fmt.Errorf("cluster %d invalid, error: %s", i, err)
+		}
+		if names[x.Name] {
+			return nil, fmt.Errorf("cluster: %s is duplicated", x.Name)
+		}
+		names[x.Name] = true
+	}
+
+	return list, nil
+}
+
+// isValid validates the cluster, normalizing the host names and path prefix
+func (r *ClusterConfig) isValid() error {
+	if r.Name == "" {
+		return fmt.Errorf("the cluster has no name")
+	}
+	if len(r.Hostnames) <= 0 && r.PathPrefix == "" {
+		return fmt.Errorf("the cluster must have host names or a path prefix")
+	}
+	if r.PathPrefix != "" {
+		if !strings.HasPrefix(r.PathPrefix, "/") || r.PathPrefix == "/" {
+			return fmt.Errorf("the path prefix: %s must be an absolute path", r.PathPrefix)
+		}
+		r.PathPrefix = strings.TrimSuffix(r.PathPrefix, "/")
+	}
+	for i, x := range r.Hostnames {
+		r.Hostnames[i] = strings.ToLower(x)
+	}
+	if r.URL == "" && r.Kubeconfig == "" {
+		return fmt.Errorf("the cluster must have a url or kubeconfig")
+	}
+	if (r.TLSCert == "") != (r.TLSKey == "") {
+		return fmt.Errorf("the certificate and private key must both be specified")
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClustersRoute(t *testing.T) {
+	clusters := &Clusters{routes: []*clusterRoute{
+		{cluster: &ClusterConfig{Name: "prod", Hostnames: []string{"prod.example.com"}, PathPrefix: "/clusters/prod"}},
+		{cluster: &ClusterConfig{Name: "staging", PathPrefix: "/clusters/staging"}},
+		{cluster: &ClusterConfig{Name: "staging-eu", PathPrefix: "/clusters/staging/eu"}},
+		{cluster: &ClusterConfig{Name: "dev", Hostnames: []string{"dev.example.com"}}},
+	}}
+
+	cases := []struct {
+		host       string
+		serverName string
+		uri        string
+		cluster    string
+		path       string
+	}{
+		{host: "prod.example.com", uri: "/api/v1/pods", cluster: "prod", path: "/api/v1/pods"},
+		{host: "PROD.example.com:6444", uri: "/api", cluster: "prod", path: "/api"},
+		{host: "kube-cover:6444", serverName: "dev.example.com", uri: "/api", cluster: "dev", path: "/api"},
+		{host: "prod.example.com", serverName: "dev.example.com", uri: "/api", cluster: "dev", path: "/api"},
+		{host: "kube-cover", uri: "/clusters/prod/api/v1/pods", cluster: "prod", path: "/api/v1/pods"},
+		{host: "kube-cover", uri: "/clusters/prod", cluster: "prod", path: "/"},
+		{host: "kube-cover", uri: "/clusters/staging/api", cluster: "staging", path: "/api"},
+		{host: "kube-cover", uri: "/clusters/staging/eu/api", cluster: "staging-eu", path: "/api"},
+		{host: "kube-cover", uri: "/clusters/production/api"},
+		{host: "kube-cover", uri: "/clusters/stagingeu/api"},
+		{host: "kube-cover", uri: "/api/v1/pods"},
+		{host: "unknown.example.com", uri: "/"},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest("GET", c.uri, nil)
+		req.Host = c.host
+		if c.serverName != "" {
+			req.TLS = &tls.ConnectionState{ServerName: c.serverName}
+		}
+		route, path := clusters.route(req)
+		var cluster string
+		if route != nil {
+			cluster = route.cluster.Name
+		}
+		if cluster != c.cluster || path != c.path {
+			t.Errorf("case %d: expected the cluster: %q, path: %q, got: %q, %q", i, c.cluster, c.path, cluster, path)
+		}
+	}
+}
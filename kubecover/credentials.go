@@ -0,0 +1,245 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gambol99/kube-cover/utils"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// upstreamCredentials are the trust and credentials used when connecting to the upstream
+type upstreamCredentials struct {
+	// the server url, taken from the kubeconfig
+	server string
+	// the pem encoded ca bundle used to verify the upstream
+	caData []byte
+	// the pem encoded client certificate
+	certData []byte
+	// the pem encoded client private key
+	keyData []byte
+	// the bearer token
+	token string
+	// skip the verification of the upstream certificate
+	insecure bool
+}
+
+// kubeConfig is the subset of the kubeconfig file used to connect to the upstream
+type kubeConfig struct {
+	// the default context
+	CurrentContext string `yaml:"current-context"`
+	// the clusters
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	// the users
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	// the contexts
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// loadUpstreamCredentials reads in the credentials from the kubeconfig, with the options overriding
+func loadUpstreamCredentials(config *Config) (*upstreamCredentials, error) {
+	credentials := new(upstreamCredentials)
+
+	// step: load the kubeconfig if required
+	if config.Kubeconfig != "" {
+		if err := credentials.loadKubeconfig(config.Kubeconfig, config.KubeContext); err != nil {
+			return nil, fmt.Errorf("unable to load the kubeconfig: %s, error: %s", config.Kubeconfig, err)
+		}
+	}
+
+	var err error
+	if config.UpstreamCA != "" {
+		if credentials.caData, err = ioutil.ReadFile(config.UpstreamCA); err != nil {
+			return nil, err
+		}
+	}
+	if config.UpstreamClientCert != "" {
+		if credentials.certData, err = ioutil.ReadFile(config.UpstreamClientCert); err != nil {
+			return nil, err
+		}
+	}
+	if config.UpstreamClientKey != "" {
+		if credentials.keyData, err = ioutil.ReadFile(config.UpstreamClientKey); err != nil {
+			return nil, err
+		}
+	}
+	if config.UpstreamToken != "" {
+		credentials.token = config.UpstreamToken
+	}
+	if config.UpstreamInsecure {
+		credentials.insecure = true
+	}
+
+	return credentials, nil
+}
+
+// loadKubeconfig reads in the cluster and user of the context from the kubeconfig
+func (r *upstreamCredentials) loadKubeconfig(path, context string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	kc := new(kubeConfig)
+	if err := yaml.Unmarshal(content, kc); err != nil {
+		return err
+	}
+	if context == "" {
+		context = kc.CurrentContext
+	}
+	glog.Infof("loading the upstream credentials from kubeconfig: %s, context: %s", path, context)
+
+	// step: find the cluster and user of the context
+	var clusterName, userName string
+	found := false
+	for _, x := range kc.Contexts {
+		if x.Name == context {
+			clusterName, userName, found = x.Context.Cluster, x.Context.User, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("the context: %s does not exist", context)
+	}
+
+	// the relative paths in the kubeconfig are relative to the file
+	base := filepath.Dir(path)
+
+	for _, x := range kc.Clusters {
+		if x.Name != clusterName {
+			continue
+		}
+		r.server = x.Cluster.Server
+		r.insecure = x.Cluster.InsecureSkipTLSVerify
+		if r.caData, err = kubeconfigData(base, x.Cluster.CertificateAuthority, x.Cluster.CertificateAuthorityData); err != nil {
+			return err
+		}
+	}
+	for _, x := range kc.Users {
+		if x.Name != userName {
+			continue
+		}
+		if r.certData, err = kubeconfigData(base, x.User.ClientCertificate, x.User.ClientCertificateData); err != nil {
+			return err
+		}
+		if r.keyData, err = kubeconfigData(base, x.User.ClientKey, x.User.ClientKeyData); err != nil {
+			return err
+		}
+		r.token = x.User.Token
+		if x.User.TokenFile != "" {
+			token, err := kubeconfigData(base, x.User.TokenFile, "")
+			if err != nil {
+				return err
+			}
+			r.token = strings.TrimSpace(string(token))
+		}
+	}
+
+	return nil
+}
+
+// tlsConfig creates the tls configuration used to connect to the upstream
+func (r *upstreamCredentials) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		Rand:               rand.Reader,
+		InsecureSkipVerify: r.insecure,
+	}
+	if r.insecure {
+		glog.Warningf("the upstream certificate will not be verified")
+	}
+
+	if len(r.caData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(r.caData) {
+			return nil, fmt.Errorf("no certificates found in the upstream ca")
+		}
+		config.RootCAs = pool
+	}
+
+	switch {
+	case len(r.certData) > 0 && len(r.keyData) > 0:
+		certificate, err := tls.X509KeyPair(r.certData, r.keyData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream client certificate, %s", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	case len(r.certData) > 0 || len(r.keyData) > 0:
+		return nil, fmt.Errorf("the upstream client certificate and private key must both be specified")
+	}
+
+	return config, nil
+}
+
+// setCredentials adds the upstream credentials to the request
+func (r *upstreamCredentials) setCredentials(req *http.Request) {
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+}
+
+// kubeconfigData retrieves the content from either the inline base64 data or the file
+func kubeconfigData(base, path, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if path == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+	if !utils.FileExists(path) {
+		return nil, fmt.Errorf("file %s does not exist", path)
+	}
+
+	return ioutil.ReadFile(path)
+}
@@ -0,0 +1,214 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+)
+
+// the largest discovery response cached, the aggregated discovery of a large cluster being a few megabytes
+const maxDiscoverySize = 8 << 20
+
+// the key of the discovery cache entry in the request context
+const discoveryContextKey contextKey = "kubecover.discovery"
+
+// discoveryCache caches the discovery responses of the upstream, i.e. /api, /apis and /version
+type discoveryCache struct {
+	sync.RWMutex
+	// the time the responses are cached
+	ttl time.Duration
+	// the responses keyed by the path and accepted content type
+	entries map[string]*discoveryEntry
+	// the version of the upstream when last retrieved
+	version string
+}
+
+// upstreamVersion is the version reported by the upstream
+type upstreamVersion struct {
+	// the version of the upstream
+	GitVersion string `json:"gitVersion"`
+}
+
+// discoveryEntry is a cached discovery response
+type discoveryEntry struct {
+	// the content type of the response
+	contentType string
+	// the entity tag of the response
+	etag string
+	// the body of the response
+	body []byte
+	// the time the entry expires
+	expires time.Time
+}
+
+// newDiscoveryCache creates a discovery cache holding the responses for the ttl
+func newDiscoveryCache(ttl time.Duration) *discoveryCache {
+	return &discoveryCache{ttl: ttl, entries: make(map[string]*discoveryEntry)}
+}
+
+// discoveryHandler serves the discovery requests from the cache, marking the misses to be cached
+// once the upstream has responded
+func (r *KubeCover) discoveryHandler() gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		if cx.Request.Method != http.MethodGet || !isDiscoveryPath(cx.Request.URL.Path) || isUpgradedConnection(cx.Request) {
+			return
+		}
+		key := cx.Request.URL.Path + "," + cx.Request.Header.Get("Accept")
+
+		entry, found := r.discovery.get(key)
+		if !found {
+			discoveryCacheCounter.WithLabelValues("miss").Inc()
+			cx.Request.Header.Del("Accept-Encoding")
+			cx.Request = cx.Request.WithContext(context.WithValue(cx.Request.Context(), discoveryContextKey, key))
+			return
+		}
+		discoveryCacheCounter.WithLabelValues("hit").Inc()
+		glog.V(10).Infof("serving the discovery request from the cache, uri: %s", cx.Request.URL.Path)
+
+		cx.Header("ETag", entry.etag)
+		if matchesETag(cx.Request.Header.Get("If-None-Match"), entry.etag) {
+			cx.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+		cx.Data(http.StatusOK, entry.contentType, entry.body)
+		cx.Abort()
+	}
+}
+
+// cacheDiscovery caches the discovery response of the upstream, tagging the response with the entity tag
+func (r *KubeCover) cacheDiscovery(resp *http.Response) error {
+	key, found := resp.Request.Context().Value(discoveryContextKey).(string)
+	if !found || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDiscoverySize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxDiscoverySize {
+		glog.Warningf("the discovery response exceeds the maximum cached, uri: %s", resp.Request.URL.Path)
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	entry := &discoveryEntry{
+		contentType: resp.Header.Get("Content-Type"),
+		etag:        resp.Header.Get("ETag"),
+		body:        body,
+	}
+	if entry.etag == "" {
+		entry.etag = fmt.Sprintf(`"%x"`, sha1.Sum(body))
+		resp.Header.Set("ETag", entry.etag)
+	}
+	// step: check the version of the upstream on every refill, invalidating the cache on an upgrade
+	version := new(upstreamVersion)
+	if resp.Request.URL.Path == "/version" {
+		err = json.Unmarshal(body, version)
+	} else {
+		err = r.upstreamRequest("GET", "/version", "", nil, version)
+	}
+	if err != nil {
+		glog.Warningf("unable to retrieve the version of the upstream, not caching the discovery, error: %s", err)
+		return nil
+	}
+	r.discovery.updateVersion(version.GitVersion)
+	r.discovery.set(key, entry)
+
+	return nil
+}
+
+// get retrieves the unexpired entry
+func (r *discoveryCache) get(key string) (*discoveryEntry, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	entry, found := r.entries[key]
+	if !found || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// set adds the entry to the cache
+func (r *discoveryCache) set(key string, entry *discoveryEntry) {
+	r.Lock()
+	defer r.Unlock()
+	entry.expires = time.Now().Add(r.ttl)
+	r.entries[key] = entry
+}
+
+// updateVersion records the version of the upstream, invalidating the cache once the version has changed
+func (r *discoveryCache) updateVersion(version string) {
+	if version == "" {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if r.version != "" && r.version != version {
+		glog.Infof("the upstream version has changed from %s to %s, invalidating the discovery cache", r.version, version)
+		r.entries = make(map[string]*discoveryEntry)
+	}
+	r.version = version
+}
+
+// isDiscoveryPath checks if the path is a discovery endpoint, i.e. /api, /apis, /version and the
+// resources of a group version
+func isDiscoveryPath(path string) bool {
+	elements := strings.Split(strings.Trim(path, "/"), "/")
+	switch elements[0] {
+	case "version":
+		return len(elements) == 1
+	case "api":
+		return len(elements) <= 2
+	case "apis":
+		return len(elements) <= 3
+	}
+
+	return false
+}
+
+// matchesETag checks if the If-None-Match header holds the entity tag
+func matchesETag(header, etag string) bool {
+	for _, x := range strings.Split(header, ",") {
+		x = strings.TrimSpace(x)
+		if x == "*" || strings.TrimPrefix(x, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
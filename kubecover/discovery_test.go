@@ -0,0 +1,79 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiscoveryCacheVersion(t *testing.T) {
+	version := "v1.10.0"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"gitVersion":%q}`, version)
+	}))
+	defer server.Close()
+
+	upstream, _ := url.Parse(server.URL)
+	cover := &KubeCover{
+		config:      &Config{},
+		client:      server.Client(),
+		upstream:    upstream,
+		credentials: &upstreamCredentials{},
+		discovery:   newDiscoveryCache(time.Minute),
+	}
+	refill := func(path string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), discoveryContextKey, path+","))
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"kind":"APIVersions"}`)),
+			Request:    req,
+		}
+		if err := cover.cacheDiscovery(resp); err != nil {
+			t.Fatalf("unable to cache the discovery, error: %s", err)
+		}
+	}
+
+	refill("/api")
+	if _, found := cover.discovery.get("/api,"); !found {
+		t.Fatal("expected the discovery to be cached")
+	}
+	refill("/apis")
+	if _, found := cover.discovery.get("/api,"); !found {
+		t.Error("expected the discovery to remain cached while the version is unchanged")
+	}
+
+	// step: an upgrade of the upstream is noticed on the next refill
+	version = "v1.11.0"
+	refill("/apis/apps/v1")
+	if _, found := cover.discovery.get("/api,"); found {
+		t.Error("expected the discovery to be invalidated once the version changed")
+	}
+	if _, found := cover.discovery.get("/apis/apps/v1,"); !found {
+		t.Error("expected the refilled discovery to be cached")
+	}
+}
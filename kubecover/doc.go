@@ -18,28 +18,191 @@ limitations under the License.
 package kubecover
 
 import (
+	"crypto/tls"
+	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"time"
 
+	"github.com/gambol99/kube-cover/auth"
+	"github.com/gambol99/kube-cover/authz"
 	"github.com/gambol99/kube-cover/policy"
 
 	"github.com/gin-gonic/gin"
+	"k8s.io/kubernetes/pkg/api/unversioned"
 )
 
 const (
 	headerUpgrade = "Upgrade"
+
+	// the key of the authenticated user in the request context
+	userContextKey = "kubecover.user"
+	// the key of the session recording in the request context
+	sessionContextKey = "kubecover.session"
+	// the key of the session limits in the request context
+	limitsContextKey = "kubecover.limits"
+
+	// the reason of the requests refused for exceeding a limit
+	statusReasonTooManyRequests unversioned.StatusReason = "TooManyRequests"
+	// the reason of the requests refused for the size of the body
+	statusReasonRequestEntityTooLarge unversioned.StatusReason = "RequestEntityTooLarge"
+
+	// ModeProxy runs the service as a reverse proxy in front of the api
+	ModeProxy = "proxy"
+	// ModeAdmission runs the service as an admission webhook for the api
+	ModeAdmission = "admission"
+	// ModeKubelet runs the service as a reverse proxy in front of the kubelet api
+	ModeKubelet = "kubelet"
+	// ModeDocker runs the service as a reverse proxy in front of the docker socket
+	ModeDocker = "docker"
 )
 
+// Config is the configuration for the kube cover service
+type Config struct {
+	// the mode the service is running in
+	Mode string
+	// the name of the cluster, when routing between clusters
+	ClusterName string
+	// the upstream k8s urls, comma separated
+	UpstreamURL string
+	// the balancing of the requests between the upstreams, round-robin or least-connections
+	UpstreamBalance string
+	// the interval of the upstream health checks
+	UpstreamHealthInterval time.Duration
+	// the time to wait for the response headers of the upstream, zero disables
+	UpstreamResponseTimeout time.Duration
+	// the total time of a request to the upstream, zero disables
+	UpstreamRequestTimeout time.Duration
+	// the total time of a watch or log follow of the upstream, zero disables
+	UpstreamStreamTimeout time.Duration
+	// the consecutive failures opening the circuit of an upstream, zero disables
+	UpstreamFailureThreshold int
+	// the time the circuit of an upstream is open before probing
+	UpstreamBreakerTimeout time.Duration
+	// the path to the ca bundle used to verify the upstream
+	UpstreamCA string
+	// the path to the client certificate presented to the upstream
+	UpstreamClientCert string
+	// the path to the client private key presented to the upstream
+	UpstreamClientKey string
+	// the bearer token presented to the upstream
+	UpstreamToken string
+	// skip the verification of the upstream certificate
+	UpstreamInsecure bool
+	// the path to a kubeconfig holding the upstream credentials
+	Kubeconfig string
+	// the context in the kubeconfig, defaults to the current context
+	KubeContext string
+	// forward the identity of the caller via the impersonation headers
+	Impersonate bool
+	// the path to the docker socket in docker mode
+	DockerSocket string
+	// the pseudo namespace used to select the policy in docker mode
+	DockerNamespace string
+	// the comma separated kubelet paths permitted besides the streams and logs in kubelet mode
+	KubeletAllowedPaths string
+	// the path to the ca bundle used to verify client certificates
+	ClientCA string
+	// the path to the csv static token file
+	TokenAuthFile string
+	// the path to the csv basic auth file
+	BasicAuthFile string
+	// the issuer of the oidc id tokens
+	OIDCIssuerURL string
+	// the client id the oidc id tokens are issued for
+	OIDCClientID string
+	// the path or url of the oidc json web key set
+	OIDCJWKS string
+	// the claim used as the username
+	OIDCUsernameClaim string
+	// the claim used as the groups
+	OIDCGroupsClaim string
+	// resolve the bearer tokens via the upstream token review api
+	TokenReview bool
+	// the time to cache the authenticated token reviews
+	TokenReviewTTL time.Duration
+	// the time to cache the rejected token reviews
+	TokenReviewNegativeTTL time.Duration
+	// permit the unauthenticated requests as the anonymous user
+	AnonymousAuth bool
+	// the requests per second of each user, namespace and verb, zero disables
+	RateLimit float64
+	// the burst of requests of each user, namespace and verb
+	RateLimitBurst int
+	// the writes per second to the workloads of each user, namespace and verb, zero disables
+	WriteRateLimit float64
+	// the burst of writes to the workloads of each user, namespace and verb
+	WriteRateLimitBurst int
+	// the maximum size of the request bodies decoded, zero being unlimited
+	MaxBodySize int64
+	// the time the discovery responses are cached, zero disables
+	DiscoveryCacheTTL time.Duration
+	// the time permitted to write a response, the streams being exempt
+	ServerWriteTimeout time.Duration
+	// the time an upgraded connection can be idle
+	UpgradeIdleTimeout time.Duration
+	// the maximum duration of an upgraded connection
+	UpgradeMaxDuration time.Duration
+	// the directory the session recordings are written to
+	SessionsDir string
+	// the path to the policy file
+	PolicyFile string
+	// the path to a shadow policy file, evaluated but not enforced
+	ShadowPolicyFile string
+	// the path to the role file authorizing the requests
+	RoleFile string
+	// the interface to expose the prometheus metrics on
+	MetricsBind string
+	// whether to run the background pod reconciler
+	Reconcile bool
+	// the action the reconciler takes on non-compliant pods
+	ReconcileAction string
+	// the grace period before the reconciler takes action
+	ReconcileGracePeriod time.Duration
+}
+
 // KubeCover is the proxy service
 type KubeCover struct {
+	// the service configuration
+	config *Config
 	// the gin engine
 	engine *gin.Engine
 	// the reverse proxy
 	proxy *httputil.ReverseProxy
-	// the upstream url
+	// the reverse proxy of the streaming responses
+	streamer *httputil.ReverseProxy
+	// the cache of the discovery responses
+	discovery *discoveryCache
+	// the transport to the upstream
+	transport *http.Transport
+	// the http client for requests to the upstream
+	client *http.Client
+	// the background pod reconciler
+	reconciler *reconciler
+	// the upstream url the requests are resolved against
 	upstream *url.URL
+	// the upstreams the requests are balanced between
+	upstreams *upstreamPool
+	// the credentials used to connect to the upstream
+	credentials *upstreamCredentials
+	// the tls configuration used to connect to the upstream
+	upstreamTLS *tls.Config
+	// the tls configuration of the proxied connections
+	proxyTLS *tls.Config
+	// whether the proxied connections carry the upstream credentials, i.e. impersonating or in front of the kubelet
+	proxyCredentials bool
 	// the upstream endpoint
 	upstreamEndpoint string
+	// the authenticator for the callers
+	authenticator auth.Authenticator
+	// the authorizer for the requests
+	authorizer authz.Authorizer
 	// the policy enforcer
 	acl policy.Controller
+	// the shadow policy, evaluated alongside the enforcer
+	shadow policy.Controller
+	// the upgraded sessions of each user
+	upgrades *sessionCounter
+	// the rate limits of the requests
+	limiter *rateLimiter
 }
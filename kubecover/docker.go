@@ -0,0 +1,392 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gambol99/kube-cover/policy"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+var (
+	// dockerCreatePath matches the container creation, optionally prefixed with the api version
+	dockerCreatePath = regexp.MustCompile(`^(/v[0-9.]+)?/containers/create$`)
+	// dockerExecPath matches the creation of an exec in a container
+	dockerExecPath = regexp.MustCompile(`^(/v[0-9.]+)?/containers/([^/]+)/exec$`)
+	// dockerVolumePath matches the volume creation
+	dockerVolumePath = regexp.MustCompile(`^(/v[0-9.]+)?/volumes/create$`)
+)
+
+// dockerCreateRequest is the body of a docker container creation
+type dockerCreateRequest struct {
+	// the image of the container
+	Image string `json:"Image"`
+	// the host configuration
+	HostConfig *dockerHostConfig `json:"HostConfig"`
+}
+
+// dockerHostConfig is the host configuration of the docker container
+type dockerHostConfig struct {
+	// whether the container is privileged
+	Privileged bool `json:"Privileged"`
+	// the capabilities added
+	CapAdd []string `json:"CapAdd"`
+	// the volume binds, i.e. /src:/dest:ro or volume:/dest
+	Binds []string `json:"Binds"`
+	// the driver of the volumes created by the binds
+	VolumeDriver string `json:"VolumeDriver"`
+	// the mounts of the container
+	Mounts []dockerMount `json:"Mounts"`
+	// the network mode, i.e. host
+	NetworkMode string `json:"NetworkMode"`
+	// the pid mode, i.e. host
+	PidMode string `json:"PidMode"`
+	// the ipc mode, i.e. host
+	IpcMode string `json:"IpcMode"`
+	// the ports bound on the host
+	PortBindings map[string][]dockerPortBinding `json:"PortBindings"`
+}
+
+// dockerMount is a mount in the docker container
+type dockerMount struct {
+	// the type of mount, bind, volume or tmpfs
+	Type string `json:"Type"`
+	// the source of the mount
+	Source string `json:"Source"`
+	// the destination in the container
+	Target string `json:"Target"`
+	// the options of the volume created for the mount
+	VolumeOptions *struct {
+		DriverConfig *dockerDriverConfig `json:"DriverConfig"`
+	} `json:"VolumeOptions"`
+}
+
+// dockerDriverConfig is the driver and options of a docker volume
+type dockerDriverConfig struct {
+	// the name of the driver
+	Name string `json:"Name"`
+	// the options of the driver, i.e. o=bind,device=/
+	Options map[string]string `json:"Options"`
+}
+
+// dockerVolumeRequest is the body of a docker volume creation, and the volume inspected
+type dockerVolumeRequest struct {
+	// the name of the volume
+	Name string `json:"Name"`
+	// the driver of the volume
+	Driver string `json:"Driver"`
+	// the options of the driver
+	DriverOpts map[string]string `json:"DriverOpts"`
+	// the options of the driver, as inspected
+	Options map[string]string `json:"Options"`
+}
+
+// dockerExecRequest is the body of the creation of an exec in a container
+type dockerExecRequest struct {
+	// whether the exec is privileged
+	Privileged bool `json:"Privileged"`
+}
+
+// volumeResolver resolves the named volume to the host path it mounts, if any
+type volumeResolver func(name string, driver *dockerDriverConfig) (string, error)
+
+// dockerPortBinding is a port bound on the host
+type dockerPortBinding struct {
+	// the host ip
+	HostIP string `json:"HostIp"`
+	// the host port
+	HostPort string `json:"HostPort"`
+}
+
+// dockerRouter creates the router filtering the requests proxied to the docker api
+func (r *KubeCover) dockerRouter() *gin.Engine {
+	router := gin.Default()
+	router.Use(r.proxyHandler(), r.dockerHandler())
+
+	return router
+}
+
+// dockerHandler authorizes the creation of containers, execs and volumes via the docker api against the
+// policy of the pseudo namespace
+func (r *KubeCover) dockerHandler() gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		if cx.Request.Method != "POST" {
+			return
+		}
+		context := &policy.PolicyContext{
+			Namespace: r.config.DockerNamespace,
+			Cluster:   r.config.ClusterName,
+		}
+
+		switch uri := cx.Request.URL.Path; {
+		case dockerCreatePath.MatchString(uri):
+			create := new(dockerCreateRequest)
+			if content, found := r.decodeDocker(cx, create); found {
+				glog.V(10).Infof("authorizating container, namespace: %s, name: %s", context.Namespace, cx.Query("name"))
+				r.authorizeDocker(cx, context, "container", cx.Query("name"), content, func() (*api.PodSpec, error) {
+					return create.podSpec(r.resolveDockerVolume)
+				})
+			}
+		case dockerExecPath.MatchString(uri):
+			id := dockerExecPath.FindStringSubmatch(uri)[2]
+			exec := new(dockerExecRequest)
+			if content, found := r.decodeDocker(cx, exec); found {
+				glog.V(10).Infof("authorizating exec, namespace: %s, container: %s", context.Namespace, id)
+				if err := r.acl.AuthorizedStream(context, policy.StreamExec, id, ""); err != nil {
+					r.unauthorizedRequest(cx, string(content), err.Error())
+					return
+				}
+				r.authorizeDocker(cx, context, "exec", id, content, exec.podSpec)
+			}
+		case dockerVolumePath.MatchString(uri):
+			volume := new(dockerVolumeRequest)
+			if content, found := r.decodeDocker(cx, volume); found {
+				glog.V(10).Infof("authorizating volume, namespace: %s, name: %s", context.Namespace, volume.Name)
+				r.authorizeDocker(cx, context, "volume", volume.Name, content, volume.podSpec)
+			}
+		}
+	}
+}
+
+// decodeDocker decodes the body of the docker request, refusing the request on a failure
+func (r *KubeCover) decodeDocker(cx *gin.Context, data interface{}) ([]byte, bool) {
+	content, err := r.decodeInput(cx.Request, data)
+	if err == errBodyTooLarge {
+		glog.Warningf("refusing the request from: (%s), the body exceeds %d bytes", cx.Request.RemoteAddr, r.config.MaxBodySize)
+		cx.AbortWithStatus(http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		glog.Errorf("unable to decode the request body, error: %s", err)
+		cx.AbortWithStatus(http.StatusBadRequest)
+		return nil, false
+	}
+
+	return content, true
+}
+
+// authorizeDocker translates the docker request into a pod spec and validates it against the policy
+func (r *KubeCover) authorizeDocker(cx *gin.Context, context *policy.PolicyContext, kind, name string, content []byte, translate func() (*api.PodSpec, error)) {
+	spec, err := translate()
+	if err != nil {
+		glog.Errorf("unable to translate the %s, error: %s", kind, err)
+		cx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	// step: validate against the policy
+	if err := r.authorize(context, kind, name, spec); err != nil {
+		r.unauthorizedRequest(cx, string(content), err.Error())
+		return
+	}
+}
+
+// resolveDockerVolume inspects the named volume for the host path it mounts, the volume being created with
+// the driver given should it not exist
+func (r *KubeCover) resolveDockerVolume(name string, driver *dockerDriverConfig) (string, error) {
+	if name != "" {
+		request, err := http.NewRequest("GET", "http://docker/volumes/"+url.PathEscape(name), nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := r.client.Do(request)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			volume := new(dockerVolumeRequest)
+			if err := json.NewDecoder(resp.Body).Decode(volume); err != nil {
+				return "", err
+			}
+			return dockerVolumeSource(volume.Driver, volume.Options), nil
+		case http.StatusNotFound:
+		default:
+			return "", upstreamError(resp)
+		}
+	}
+	if driver == nil {
+		return "", nil
+	}
+
+	return dockerVolumeSource(driver.Name, driver.Options), nil
+}
+
+// podSpec translates the exec into the equivalent pod spec
+func (r *dockerExecRequest) podSpec() (*api.PodSpec, error) {
+	privileged := r.Privileged
+
+	return &api.PodSpec{
+		Containers: []api.Container{{Name: "docker", SecurityContext: &api.SecurityContext{Privileged: &privileged}}},
+	}, nil
+}
+
+// podSpec translates the volume into the equivalent pod spec, a volume of the local driver binding a host
+// path being a host path
+func (r *dockerVolumeRequest) podSpec() (*api.PodSpec, error) {
+	return &api.PodSpec{
+		Volumes:    []api.Volume{dockerVolume("volume", dockerVolumeSource(r.Driver, r.DriverOpts))},
+		Containers: []api.Container{{Name: "docker", SecurityContext: &api.SecurityContext{}}},
+	}, nil
+}
+
+// dockerVolumeSource returns the host path bound by a volume of the local driver, i.e. o=bind,device=/
+func dockerVolumeSource(driver string, options map[string]string) string {
+	if driver != "" && driver != "local" {
+		return ""
+	}
+	for _, x := range strings.Split(options["o"], ",") {
+		if strings.TrimSpace(x) == "bind" || strings.TrimSpace(x) == "rbind" {
+			return options["device"]
+		}
+	}
+
+	return ""
+}
+
+// podSpec translates the docker container into the equivalent pod spec, the named volumes being resolved
+// to the host paths they mount
+func (r *dockerCreateRequest) podSpec(resolve volumeResolver) (*api.PodSpec, error) {
+	container := api.Container{
+		Name:            "docker",
+		Image:           r.Image,
+		SecurityContext: &api.SecurityContext{},
+	}
+	spec := &api.PodSpec{}
+
+	if hc := r.HostConfig; hc != nil {
+		privileged := hc.Privileged
+		container.SecurityContext.Privileged = &privileged
+		if len(hc.CapAdd) > 0 {
+			container.SecurityContext.Capabilities = &api.Capabilities{}
+			for _, x := range hc.CapAdd {
+				container.SecurityContext.Capabilities.Add = append(container.SecurityContext.Capabilities.Add,
+					api.Capability(strings.TrimPrefix(strings.ToUpper(x), "CAP_")))
+			}
+		}
+		spec.HostNetwork = hc.NetworkMode == "host"
+		spec.HostPID = hc.PidMode == "host"
+		spec.HostIPC = hc.IpcMode == "host"
+
+		// step: convert the binds and mounts into volumes
+		for i, x := range hc.Binds {
+			source := strings.Split(x, ":")[0]
+			if !strings.HasPrefix(source, "/") {
+				var err error
+				if source, err = resolve(source, &dockerDriverConfig{Name: hc.VolumeDriver}); err != nil {
+					return nil, fmt.Errorf("unable to resolve the volume: %s, %s", x, err)
+				}
+			}
+			spec.Volumes = append(spec.Volumes, dockerVolume(fmt.Sprintf("bind-%d", i), source))
+		}
+		for i, x := range hc.Mounts {
+			var source string
+			switch x.Type {
+			case "bind":
+				source = x.Source
+			case "volume":
+				var driver *dockerDriverConfig
+				if x.VolumeOptions != nil {
+					driver = x.VolumeOptions.DriverConfig
+				}
+				var err error
+				if source, err = resolve(x.Source, driver); err != nil {
+					return nil, fmt.Errorf("unable to resolve the volume: %s, %s", x.Source, err)
+				}
+			}
+			spec.Volumes = append(spec.Volumes, dockerVolume(fmt.Sprintf("mount-%d", i), source))
+		}
+
+		// step: convert the port bindings, every port of a range being bound
+		for port, bindings := range hc.PortBindings {
+			containerStart, containerEnd, err := dockerPortRange(strings.Split(port, "/")[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid container port: %s", port)
+			}
+			for _, x := range bindings {
+				if x.HostPort == "" {
+					continue
+				}
+				start, end, err := dockerPortRange(x.HostPort)
+				if err != nil {
+					return nil, fmt.Errorf("invalid host port: %s, bound to: %s", x.HostPort, port)
+				}
+				for hostPort := start; hostPort <= end; hostPort++ {
+					// step: a single container port is bound to one of the host range
+					containerPort := containerStart
+					if containerEnd > containerStart {
+						containerPort += hostPort - start
+					}
+					container.Ports = append(container.Ports, api.ContainerPort{
+						ContainerPort: containerPort,
+						HostPort:      hostPort,
+						HostIP:        x.HostIP,
+					})
+				}
+			}
+		}
+	}
+	spec.Containers = []api.Container{container}
+
+	return spec, nil
+}
+
+// dockerVolume converts a docker volume source into a volume, host paths are absolute and
+// everything else is local storage
+func dockerVolume(name, source string) api.Volume {
+	volume := api.Volume{Name: name}
+	if strings.HasPrefix(source, "/") {
+		volume.HostPath = &api.HostPathVolumeSource{Path: source}
+	} else {
+		volume.EmptyDir = &api.EmptyDirVolumeSource{}
+	}
+
+	return volume
+}
+
+// dockerPortRange parses a port or range of ports, i.e. 8080 or 8000-8010
+func dockerPortRange(value string) (int, int, error) {
+	parts := strings.SplitN(value, "-", 2)
+	start, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end := start
+	if len(parts) > 1 {
+		if end, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if start < 0 || end < start || end > 65535 {
+		return 0, 0, fmt.Errorf("invalid port range: %s", value)
+	}
+
+	return start, end, nil
+}
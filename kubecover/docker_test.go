@@ -0,0 +1,174 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDockerCreatePodSpec(t *testing.T) {
+	volumes := map[string]string{"rootfs": "/", "data": ""}
+	resolve := func(name string, driver *dockerDriverConfig) (string, error) {
+		if source, found := volumes[name]; found {
+			return source, nil
+		}
+		if name == "broken" {
+			return "", fmt.Errorf("inspection failed")
+		}
+		if driver == nil {
+			return "", nil
+		}
+		return dockerVolumeSource(driver.Name, driver.Options), nil
+	}
+
+	cases := []struct {
+		request   *dockerCreateRequest
+		hostPaths []string
+		hostPorts []int
+		err       bool
+	}{
+		{request: &dockerCreateRequest{Image: "busybox"}},
+		{
+			request:   &dockerCreateRequest{HostConfig: &dockerHostConfig{Binds: []string{"/etc:/host/etc:ro", "data:/data"}}},
+			hostPaths: []string{"/etc"},
+		},
+		{
+			request:   &dockerCreateRequest{HostConfig: &dockerHostConfig{Binds: []string{"rootfs:/host"}}},
+			hostPaths: []string{"/"},
+		},
+		{
+			request:   &dockerCreateRequest{HostConfig: &dockerHostConfig{Mounts: []dockerMount{{Type: "volume", Source: "rootfs", Target: "/host"}}}},
+			hostPaths: []string{"/"},
+		},
+		{
+			request: &dockerCreateRequest{HostConfig: &dockerHostConfig{Mounts: []dockerMount{{
+				Type:   "volume",
+				Source: "new",
+				Target: "/host",
+				VolumeOptions: &struct {
+					DriverConfig *dockerDriverConfig `json:"DriverConfig"`
+				}{DriverConfig: &dockerDriverConfig{Name: "local", Options: map[string]string{"type": "none", "o": "bind", "device": "/var/run"}}},
+			}}}},
+			hostPaths: []string{"/var/run"},
+		},
+		{
+			request: &dockerCreateRequest{HostConfig: &dockerHostConfig{Mounts: []dockerMount{{Type: "volume", Source: "broken", Target: "/host"}}}},
+			err:     true,
+		},
+		{
+			request:   &dockerCreateRequest{HostConfig: &dockerHostConfig{Mounts: []dockerMount{{Type: "bind", Source: "/var", Target: "/var"}, {Type: "tmpfs", Target: "/tmp"}}}},
+			hostPaths: []string{"/var"},
+		},
+		{
+			request: &dockerCreateRequest{HostConfig: &dockerHostConfig{PortBindings: map[string][]dockerPortBinding{
+				"80/tcp": {{HostPort: "8080"}, {HostPort: ""}},
+			}}},
+			hostPorts: []int{8080},
+		},
+		{
+			request: &dockerCreateRequest{HostConfig: &dockerHostConfig{PortBindings: map[string][]dockerPortBinding{
+				"80/tcp": {{HostPort: "1-3"}},
+			}}},
+			hostPorts: []int{1, 2, 3},
+		},
+		{
+			request: &dockerCreateRequest{HostConfig: &dockerHostConfig{PortBindings: map[string][]dockerPortBinding{
+				"8000-8001/tcp": {{HostPort: "9000-9001"}},
+			}}},
+			hostPorts: []int{9000, 9001},
+		},
+		{
+			request: &dockerCreateRequest{HostConfig: &dockerHostConfig{PortBindings: map[string][]dockerPortBinding{
+				"80/tcp": {{HostPort: "3-1"}},
+			}}},
+			err: true,
+		},
+		{
+			request: &dockerCreateRequest{HostConfig: &dockerHostConfig{PortBindings: map[string][]dockerPortBinding{
+				"80/tcp": {{HostPort: "http"}},
+			}}},
+			err: true,
+		},
+	}
+	for i, c := range cases {
+		spec, err := c.request.podSpec(resolve)
+		if c.err {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		var hostPaths []string
+		for _, x := range spec.Volumes {
+			if x.HostPath != nil {
+				hostPaths = append(hostPaths, x.HostPath.Path)
+			}
+		}
+		if fmt.Sprint(hostPaths) != fmt.Sprint(c.hostPaths) {
+			t.Errorf("case %d: expected the host paths: %v, got: %v", i, c.hostPaths, hostPaths)
+		}
+		hostPorts := make(map[int]bool)
+		for _, x := range spec.Containers[0].Ports {
+			hostPorts[x.HostPort] = true
+		}
+		if len(hostPorts) != len(c.hostPorts) {
+			t.Errorf("case %d: expected the host ports: %v, got: %v", i, c.hostPorts, spec.Containers[0].Ports)
+		}
+		for _, x := range c.hostPorts {
+			if !hostPorts[x] {
+				t.Errorf("case %d: expected the host port: %d to be checked", i, x)
+			}
+		}
+	}
+}
+
+func TestDockerVolumeSource(t *testing.T) {
+	cases := []struct {
+		driver  string
+		options map[string]string
+		source  string
+	}{
+		{driver: "local", options: map[string]string{"type": "none", "o": "bind", "device": "/"}, source: "/"},
+		{driver: "", options: map[string]string{"o": "ro,rbind", "device": "/etc"}, source: "/etc"},
+		{driver: "local", options: map[string]string{"type": "nfs", "o": "addr=10.0.0.1", "device": ":/export"}},
+		{driver: "local"},
+		{driver: "rexray", options: map[string]string{"o": "bind", "device": "/"}},
+	}
+	for i, c := range cases {
+		if source := dockerVolumeSource(c.driver, c.options); source != c.source {
+			t.Errorf("case %d: expected the source: %q, got: %q", i, c.source, source)
+		}
+	}
+}
+
+func TestDockerExecPodSpec(t *testing.T) {
+	for _, privileged := range []bool{true, false} {
+		spec, err := (&dockerExecRequest{Privileged: privileged}).podSpec()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if *spec.Containers[0].SecurityContext.Privileged != privileged {
+			t.Errorf("expected the exec privileged: %t", privileged)
+		}
+	}
+}
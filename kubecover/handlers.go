@@ -18,68 +18,149 @@ limitations under the License.
 package kubecover
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/gambol99/kube-cover/auth"
+	"github.com/gambol99/kube-cover/authz"
 	"github.com/gambol99/kube-cover/policy"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/auth/user"
 )
 
 // handleReplicationController handles and filter the replication controller operations
 func (r *KubeCover) handleReplicationController(cx *gin.Context) {
+	r.authorizeManifests(cx, manifestReplicationController)
+}
+
+// handlePods handles the changes made to pods
+func (r *KubeCover) handlePods(cx *gin.Context) {
+	r.authorizeManifests(cx, manifestPod)
+}
+
+// authorizeManifests decodes the pod specs from the request body, a single object or the items
+// of a list, and validates each against the policy
+func (r *KubeCover) authorizeManifests(cx *gin.Context, kind string) {
 	context, err := r.deriveContext(cx)
 	if err != nil {
 		cx.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	// step: decode the controller
-	controller := new(api.ReplicationController)
-	content, err := r.decodeInput(cx.Request, controller)
+	// step: read in the request body
+	content, err := r.readBody(cx.Request)
+	if err == errBodyTooLarge {
+		glog.Warningf("refusing the request from: (%s), uri: %s, the body exceeds %d bytes", cx.Request.RemoteAddr, cx.Request.URL.Path, r.config.MaxBodySize)
+		r.statusResponse(cx, http.StatusRequestEntityTooLarge, statusReasonRequestEntityTooLarge,
+			fmt.Sprintf("the request body exceeds the maximum size of %d bytes", r.config.MaxBodySize))
+		return
+	}
 	if err != nil {
+		glog.Errorf("unable to read the request body, error: %s", err)
 		cx.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	glog.V(10).Infof("authorizating replication controller, namespace: %s, name: %s", context.Namespace, controller.Name)
-
-	// step: validate against the policy
-	if err := r.acl.Authorized(context, &controller.Spec.Template.Spec); err != nil {
-		r.unauthorizedRequest(cx, content, err.Error())
+	// step: validate each of the pod specs against the policy, the lists being accepted by the collections
+	var violation error
+	lists, patch := cx.Request.Method == "POST", cx.Request.Method == "PATCH"
+	err = decodeManifests(content, kind, lists, patch, func(name string, spec *api.PodSpec) error {
+		glog.V(10).Infof("authorizating %s, namespace: %s, name: %s", kind, context.Namespace, name)
+		violation = r.authorize(context, kind, name, spec)
+		return violation
+	})
+	if violation != nil {
+		r.unauthorizedRequest(cx, string(content), violation.Error())
 		return
 	}
-}
-
-// handlePods handles the changes made to pods
-func (r *KubeCover) handlePods(cx *gin.Context) {
-	context, err := r.deriveContext(cx)
 	if err != nil {
-		glog.Errorf("unable to retrieve the request content")
+		glog.Errorf("unable to decode the request body, error: %s", err)
 		cx.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
+}
 
-	// step: decode the pod spec
-	pod := new(api.PodTemplateSpec)
-	content, err := r.decodeInput(cx.Request, pod)
+// handleStream authorizes the streaming operations against a pod via the api
+func (r *KubeCover) handleStream(operation policy.StreamOperation) gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		r.authorizeStream(cx, operation, cx.Param("name"), cx.Query("container"))
+		if !cx.IsAborted() {
+			r.prepareSession(cx, operation, cx.Param("name"), cx.Query("container"))
+		}
+	}
+}
+
+// authorizeStream validates the streaming operation against the policy
+func (r *KubeCover) authorizeStream(cx *gin.Context, operation policy.StreamOperation, pod, container string) {
+	context, err := r.deriveContext(cx)
 	if err != nil {
-		glog.Errorf("unable to decode the request body, error: %s", err)
 		cx.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	glog.V(10).Infof("authorizating pod, namespace: %s, name: %s", context.Namespace, pod.Name)
+	glog.V(10).Infof("authorizating %s, namespace: %s, pod: %s, container: %s", operation, context.Namespace, pod, container)
 
 	// step: validate against the policy
-	if err := r.acl.Authorized(context, &pod.Spec); err != nil {
-		r.unauthorizedRequest(cx, content, err.Error())
+	if err := r.acl.AuthorizedStream(context, operation, pod, container); err != nil {
+		r.unauthorizedRequest(cx, fmt.Sprintf("%s %s", cx.Request.Method, cx.Request.URL.Path), err.Error())
 		return
 	}
 }
 
+// authenticationHandler authenticates the caller, rejecting or tagging as anonymous the requests
+// without credentials
+func (r *KubeCover) authenticationHandler() gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		identity, found, err := r.authenticator.AuthenticateRequest(cx.Request)
+		if err != nil {
+			glog.Errorf("failed to authenticate the request from: (%s), error: %s", cx.Request.RemoteAddr, err)
+			r.statusResponse(cx, http.StatusUnauthorized, unversioned.StatusReasonUnauthorized, "Unauthorized")
+			return
+		}
+		if !found {
+			if !r.config.AnonymousAuth {
+				glog.Warningf("refusing the unauthenticated request from: (%s), uri: %s", cx.Request.RemoteAddr, cx.Request.URL.Path)
+				r.statusResponse(cx, http.StatusUnauthorized, unversioned.StatusReasonUnauthorized, "Unauthorized")
+				return
+			}
+			identity = &user.DefaultInfo{
+				Name:   auth.AnonymousUser,
+				Groups: []string{auth.AnonymousGroup},
+			}
+		}
+		glog.V(10).Infof("authenticated the request, user: %s, groups: %v", identity.GetName(), identity.GetGroups())
+
+		cx.Set(userContextKey, identity)
+	}
+}
+
+// authorizationHandler refuses the requests not permitted by the roles before they are proxied
+func (r *KubeCover) authorizationHandler() gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		attrs := authz.NewRequestAttributes(cx.Request)
+		attrs.User = r.identity(cx)
+		if err := r.authorizer.Authorize(attrs); err != nil {
+			glog.Warningf("refusing the request from: (%s), uri: %s, %s", cx.Request.RemoteAddr, cx.Request.URL.Path, err)
+			r.statusResponse(cx, http.StatusForbidden, unversioned.StatusReasonForbidden, err.Error())
+			return
+		}
+	}
+}
+
+// identity returns the authenticated user of the request, if any
+func (r *KubeCover) identity(cx *gin.Context) user.Info {
+	if value, found := cx.Get(userContextKey); found {
+		return value.(user.Info)
+	}
+
+	return nil
+}
+
 // proxyHandler proxies the request on to the upstream endpoint
 func (r *KubeCover) proxyHandler() gin.HandlerFunc {
 	return func(cx *gin.Context) {
@@ -92,26 +173,81 @@ func (r *KubeCover) proxyHandler() gin.HandlerFunc {
 			return
 		}
 
+		// step: forward the identity of the caller if required
+		if r.config.Impersonate {
+			if err := r.impersonate(cx); err != nil {
+				glog.Errorf("refusing to proxy the request from: (%s), %s", cx.Request.RemoteAddr, err)
+				cx.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		}
+
 		// step: is this connection upgrading?
 		if isUpgradedConnection(cx.Request) {
 			glog.V(10).Infof("upgrading the connnection to %s", cx.Request.Header.Get("Upgrade"))
 			if err := r.tryUpdateConnection(cx); err != nil {
 				glog.Errorf("unable to upgrade the connection, %s", err)
-				cx.AbortWithStatus(http.StatusInternalServerError)
+				if errors.Is(err, errCircuitOpen) {
+					r.statusResponse(cx, http.StatusServiceUnavailable, unversioned.StatusReasonServiceUnavailable, "the upstream is unavailable, the circuit is open")
+					return
+				}
+				r.statusResponse(cx, http.StatusBadGateway, unversioned.StatusReasonServiceUnavailable, "unable to upgrade the connection to the upstream")
 				return
 			}
 			return
 		}
 
+		// step: is the response streamed?
+		if isStreamingRequest(cx.Request) {
+			glog.V(15).Infof("streaming the response, uri: %s", cx.Request.URL.Path)
+			r.serveStream(cx)
+			return
+		}
+
 		glog.V(15).Infof("proxying on the request, uri: %s", cx.Request.URL.Path)
 
 		r.proxy.ServeHTTP(cx.Writer, cx.Request)
 	}
 }
 
+// modifyResponse caches the discovery responses and redacts the objects returned by the upstream
+func (r *KubeCover) modifyResponse(resp *http.Response) error {
+	if r.discovery != nil {
+		if err := r.cacheDiscovery(resp); err != nil {
+			return err
+		}
+	}
+
+	return r.redactResponse(resp)
+}
+
+// authorize validates the pod spec against the active policy, evaluating the shadow policy
+// alongside and recording any difference in the decision
+func (r *KubeCover) authorize(context *policy.PolicyContext, kind, name string, spec *api.PodSpec) error {
+	err := r.acl.Authorized(context, spec)
+	if r.shadow == nil {
+		return err
+	}
+
+	// step: evaluate the shadow policy, the result is never enforced
+	shadowErr := r.shadow.Authorized(context, spec)
+	active, shadow := policyDecision(err), policyDecision(shadowErr)
+	if active != shadow {
+		glog.Warningf("shadow policy decision differs, namespace: %s, object: %s/%s, active: %s (%s), shadow: %s (%s)",
+			context.Namespace, kind, name, active, policyViolation(err), shadow, policyViolation(shadowErr))
+		shadowDifferenceCounter.WithLabelValues(context.Namespace, kind, active, shadow).Inc()
+	}
+
+	return err
+}
+
 // unauthorizedRequest sends back a failure to the client
 func (r KubeCover) unauthorizedRequest(cx *gin.Context, spec, message string) {
-	glog.Errorf("unauthorized request from: (%s), failure: %s violation", cx.Request.RemoteAddr, message)
+	var cluster string
+	if r.config.ClusterName != "" {
+		cluster = ", cluster: " + r.config.ClusterName
+	}
+	glog.Errorf("unauthorized request from: (%s)%s, failure: %s violation", cx.Request.RemoteAddr, cluster, message)
 	glog.Errorf("failing specification: %s", spec)
 
 	// step: inject the header
@@ -122,6 +258,18 @@ func (r KubeCover) unauthorizedRequest(cx *gin.Context, spec, message string) {
 	cx.Abort()
 }
 
+// statusResponse sends back a kubernetes status failure to the client
+func (r KubeCover) statusResponse(cx *gin.Context, code int, reason unversioned.StatusReason, message string) {
+	cx.JSON(code, &unversioned.Status{
+		TypeMeta: unversioned.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   unversioned.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     code,
+	})
+	cx.Abort()
+}
+
 // deriveContext gather's additional content for the authorization
 func (r *KubeCover) deriveContext(cx *gin.Context) (*policy.PolicyContext, error) {
 	namespace := cx.Param("namespace")
@@ -131,5 +279,7 @@ func (r *KubeCover) deriveContext(cx *gin.Context) (*policy.PolicyContext, error
 
 	return &policy.PolicyContext{
 		Namespace: namespace,
+		Cluster:   r.config.ClusterName,
+		User:      r.identity(cx),
 	}, nil
 }
@@ -0,0 +1,115 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gambol99/kube-cover/policy"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+const (
+	headerAuthorization     = "Authorization"
+	headerImpersonatePrefix = "Impersonate-"
+	headerImpersonateUser   = "Impersonate-User"
+	headerImpersonateGroup  = "Impersonate-Group"
+)
+
+// impersonationHandler validates any impersonation requested by the client against the policy,
+// the impersonated user replacing the caller as the identity of the request
+func (r *KubeCover) impersonationHandler() gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		target, found, err := requestedImpersonation(cx.Request.Header)
+		if err != nil {
+			r.statusResponse(cx, http.StatusBadRequest, unversioned.StatusReasonBadRequest, err.Error())
+			return
+		}
+		if !found {
+			return
+		}
+
+		caller := r.identity(cx)
+		if err := r.acl.AuthorizedImpersonation(&policy.PolicyContext{Cluster: r.config.ClusterName, User: caller}, target); err != nil {
+			glog.Warningf("refusing the impersonation from: (%s), uri: %s, error: %s", cx.Request.RemoteAddr, cx.Request.URL.Path, err)
+			r.statusResponse(cx, http.StatusForbidden, unversioned.StatusReasonForbidden, fmt.Sprintf("forbidden: %s", err))
+			return
+		}
+		glog.V(4).Infof("user: %s impersonating user: %s, groups: %v", caller.GetName(), target.GetName(), target.GetGroups())
+
+		cx.Set(userContextKey, target)
+	}
+}
+
+// requestedImpersonation extracts the user the client has requested to impersonate
+func requestedImpersonation(header http.Header) (user.Info, bool, error) {
+	for name := range header {
+		name = http.CanonicalHeaderKey(name)
+		if strings.HasPrefix(name, headerImpersonatePrefix) && name != headerImpersonateUser && name != headerImpersonateGroup {
+			return nil, false, fmt.Errorf("the impersonation header: %s is not supported", name)
+		}
+	}
+	name := header.Get(headerImpersonateUser)
+	groups := header[headerImpersonateGroup]
+	if name == "" {
+		if len(groups) > 0 {
+			return nil, false, fmt.Errorf("impersonating groups requires a user to impersonate")
+		}
+		return nil, false, nil
+	}
+
+	return &user.DefaultInfo{Name: name, Groups: groups}, true, nil
+}
+
+// impersonate replaces the client credentials and any impersonation headers with the verified
+// identity of the request, the upstream authenticating the proxy via its own credentials
+func (r *KubeCover) impersonate(cx *gin.Context) error {
+	identity := r.identity(cx)
+	if identity == nil || identity.GetName() == "" {
+		return fmt.Errorf("the request has no authenticated identity to impersonate")
+	}
+
+	removeImpersonation(cx.Request.Header)
+	cx.Request.Header.Del(headerAuthorization)
+	setImpersonation(cx.Request.Header, identity)
+
+	return nil
+}
+
+// setImpersonation adds the impersonation headers for the user
+func setImpersonation(header http.Header, identity user.Info) {
+	header.Set(headerImpersonateUser, identity.GetName())
+	for _, group := range identity.GetGroups() {
+		header.Add(headerImpersonateGroup, group)
+	}
+}
+
+// removeImpersonation removes any impersonation headers
+func removeImpersonation(header http.Header) {
+	for name := range header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(name), headerImpersonatePrefix) {
+			header.Del(name)
+		}
+	}
+}
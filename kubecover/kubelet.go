@@ -0,0 +1,124 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gambol99/kube-cover/policy"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+// kubeletRouter creates the router filtering the requests proxied to the kubelet api, the paths not
+// checked against the policy being refused unless permitted
+func (r *KubeCover) kubeletRouter(allowed []string) *gin.Engine {
+	router := gin.Default()
+	router.Use(r.authenticationHandler(), r.proxyHandler())
+	router.NoRoute(r.kubeletPathHandler(allowed))
+
+	// step: the container paths can optionally include the pod uid, i.e. /exec/namespace/pod/uid/container
+	for path, operation := range map[string]policy.StreamOperation{
+		"/exec/:namespace/:pod/*container":   policy.StreamExec,
+		"/run/:namespace/:pod/*container":    policy.StreamExec,
+		"/attach/:namespace/:pod/*container": policy.StreamAttach,
+	} {
+		router.GET(path, r.handleKubeletStream(operation))
+		router.POST(path, r.handleKubeletStream(operation))
+	}
+	router.GET("/containerLogs/:namespace/:pod/*container", r.handleKubeletLogs)
+
+	portForward := "/portForward/:namespace/:pod"
+	portForwardUID := "/portForward/:namespace/:pod/*uid"
+	router.GET(portForward, r.handleKubeletStream(policy.StreamPortForward))
+	router.POST(portForward, r.handleKubeletStream(policy.StreamPortForward))
+	router.GET(portForwardUID, r.handleKubeletStream(policy.StreamPortForward))
+	router.POST(portForwardUID, r.handleKubeletStream(policy.StreamPortForward))
+
+	return router
+}
+
+// handleKubeletStream authorizes the streaming operations against a pod via the kubelet api
+func (r *KubeCover) handleKubeletStream(operation policy.StreamOperation) gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		var container string
+		if operation != policy.StreamPortForward {
+			elements := strings.Split(strings.Trim(cx.Param("container"), "/"), "/")
+			container = elements[len(elements)-1]
+		}
+
+		r.authorizeStream(cx, operation, cx.Param("pod"), container)
+		if !cx.IsAborted() {
+			r.prepareSession(cx, operation, cx.Param("pod"), container)
+		}
+	}
+}
+
+// handleKubeletLogs authorizes reading the logs of a container via the kubelet api
+func (r *KubeCover) handleKubeletLogs(cx *gin.Context) {
+	r.authorizeLogs(cx, cx.Param("pod"), strings.Trim(cx.Param("container"), "/"))
+}
+
+// kubeletPathHandler refuses the requests to the kubelet paths which are not permitted, i.e. the host logs,
+// pods, configz or debug endpoints
+func (r *KubeCover) kubeletPathHandler(allowed []string) gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		if kubeletPathPermitted(allowed, cx.Request.URL.Path) {
+			return
+		}
+		glog.Warningf("refusing the request to the kubelet from: (%s), uri: %s, the path is not permitted", cx.Request.RemoteAddr, cx.Request.URL.Path)
+		r.statusResponse(cx, http.StatusForbidden, unversioned.StatusReasonForbidden,
+			fmt.Sprintf("the kubelet path: %s is not permitted", cx.Request.URL.Path))
+	}
+}
+
+// kubeletPathPermitted checks the path matches one of the permitted, the path having to be clean
+// as the wildcards would otherwise match the parent directories
+func kubeletPathPermitted(allowed []string, uri string) bool {
+	if uri != path.Clean(uri) {
+		return false
+	}
+	for _, x := range allowed {
+		if matched, _ := path.Match(x, uri); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// kubeletAllowedPaths parses and validates the comma separated kubelet paths
+func kubeletAllowedPaths(paths string) ([]string, error) {
+	var allowed []string
+	for _, x := range strings.Split(paths, ",") {
+		if x = strings.TrimSpace(x); x == "" {
+			continue
+		}
+		if _, err := path.Match(x, ""); err != nil || !strings.HasPrefix(x, "/") {
+			return nil, fmt.Errorf("invalid kubelet path: %s", x)
+		}
+		allowed = append(allowed, x)
+	}
+
+	return allowed, nil
+}
@@ -0,0 +1,111 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+// handleLogs authorizes the reading the logs of a pod via the api
+func (r *KubeCover) handleLogs(cx *gin.Context) {
+	container := cx.Query("container")
+	if container == "" {
+		container = r.defaultContainer(cx.Param("namespace"), cx.Param("name"))
+	}
+
+	r.authorizeLogs(cx, cx.Param("name"), container)
+}
+
+// authorizeLogs validates the logs request against the policy, rewriting the query with the capped options
+func (r *KubeCover) authorizeLogs(cx *gin.Context, pod, container string) {
+	context, err := r.deriveContext(cx)
+	if err != nil {
+		cx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	options, err := podLogOptions(cx.Request, container)
+	if err != nil {
+		r.statusResponse(cx, http.StatusBadRequest, unversioned.StatusReasonBadRequest, err.Error())
+		return
+	}
+
+	glog.V(10).Infof("authorizating the logs, namespace: %s, pod: %s, container: %s", context.Namespace, pod, container)
+
+	// step: validate against the policy
+	if err := r.acl.AuthorizedLogs(context, pod, options); err != nil {
+		r.unauthorizedRequest(cx, fmt.Sprintf("%s %s", cx.Request.Method, cx.Request.URL.Path), err.Error())
+		return
+	}
+
+	// step: apply the capped limits to the request
+	query := cx.Request.URL.Query()
+	if options.LimitBytes != nil {
+		query.Set("limitBytes", strconv.FormatInt(*options.LimitBytes, 10))
+	}
+	if options.TailLines != nil {
+		query.Set("tailLines", strconv.FormatInt(*options.TailLines, 10))
+	}
+	cx.Request.URL.RawQuery = query.Encode()
+}
+
+// podLogOptions parses the options of the logs request which are subject to the policy
+func podLogOptions(req *http.Request, container string) (*api.PodLogOptions, error) {
+	query := req.URL.Query()
+	options := &api.PodLogOptions{Container: container}
+	if value := query.Get("previous"); value != "" {
+		previous, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous: %s", value)
+		}
+		options.Previous = previous
+	}
+	for name, field := range map[string]**int64{"limitBytes": &options.LimitBytes, "tailLines": &options.TailLines} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", name, value)
+		}
+		*field = &number
+	}
+
+	return options, nil
+}
+
+// defaultContainer retrieves the container of a single container pod, the container defaulted by the api
+func (r *KubeCover) defaultContainer(namespace, name string) string {
+	pod := new(api.Pod)
+	if err := r.upstreamRequest("GET", fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", namespace, name), "", nil, pod); err != nil {
+		glog.Warningf("unable to retrieve the pod: %s/%s, error: %s", namespace, name, err)
+		return ""
+	}
+	if len(pod.Spec.Containers) != 1 {
+		return ""
+	}
+
+	return pod.Spec.Containers[0].Name
+}
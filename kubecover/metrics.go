@@ -0,0 +1,107 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// shadowDifferenceCounter counts the decisions where the shadow policy differs from the active
+	shadowDifferenceCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kubecover",
+			Name:      "shadow_policy_differences_total",
+			Help:      "The number of requests where the shadow policy decision differs from the active policy",
+		},
+		[]string{"namespace", "kind", "active", "shadow"},
+	)
+	// discoveryCacheCounter counts the discovery requests served from and missing the cache
+	discoveryCacheCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kubecover",
+			Name:      "discovery_cache_requests_total",
+			Help:      "The number of discovery requests served from (hit) or missing (miss) the cache",
+		},
+		[]string{"result"},
+	)
+	// rateLimitedCounter counts the requests refused by the rate limits
+	rateLimitedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kubecover",
+			Name:      "rate_limited_requests_total",
+			Help:      "The number of requests refused by the rate limits of the requests or workload writes",
+		},
+		[]string{"limit"},
+	)
+	// upstreamHealthGauge is the health of each upstream
+	upstreamHealthGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "kubecover",
+			Name:      "upstream_healthy",
+			Help:      "Whether the upstream is passing the health checks (1) or not (0)",
+		},
+		[]string{"upstream"},
+	)
+	// upstreamCircuitGauge is the state of the circuit breaker of each upstream
+	upstreamCircuitGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "kubecover",
+			Name:      "upstream_circuit_open",
+			Help:      "Whether the circuit breaker of the upstream is open (1) or closed (0)",
+		},
+		[]string{"upstream"},
+	)
+	// reconcileViolationCounter counts the non-compliant pods found by the reconciler
+	reconcileViolationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kubecover",
+			Name:      "reconciler_violations_total",
+			Help:      "The number of non-compliant pods found by the reconciler",
+		},
+		[]string{"namespace"},
+	)
+	// reconcileActionCounter counts the actions taken by the reconciler
+	reconcileActionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "kubecover",
+			Name:      "reconciler_actions_total",
+			Help:      "The number of actions taken against non-compliant pods by the reconciler",
+		},
+		[]string{"namespace", "action"},
+	)
+	// reconcileNonCompliantGauge is the number of non-compliant pods currently running
+	reconcileNonCompliantGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "kubecover",
+			Name:      "reconciler_noncompliant_pods",
+			Help:      "The number of non-compliant pods currently known to the reconciler",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(shadowDifferenceCounter)
+	prometheus.MustRegister(discoveryCacheCounter)
+	prometheus.MustRegister(rateLimitedCounter)
+	prometheus.MustRegister(upstreamHealthGauge)
+	prometheus.MustRegister(upstreamCircuitGauge)
+	prometheus.MustRegister(reconcileViolationCounter)
+	prometheus.MustRegister(reconcileActionCounter)
+	prometheus.MustRegister(reconcileNonCompliantGauge)
+}
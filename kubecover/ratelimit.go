@@ -0,0 +1,169 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gambol99/kube-cover/auth"
+	"github.com/gambol99/kube-cover/authz"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/ratelimit"
+)
+
+// the interval the idle buckets are removed on
+const rateLimitSweepInterval = time.Minute
+
+// workloadResources are the resources whose writes are limited separately
+var workloadResources = map[string]bool{
+	"pods":                   true,
+	"replicationcontrollers": true,
+	"deployments":            true,
+	"replicasets":            true,
+	"daemonsets":             true,
+	"statefulsets":           true,
+	"jobs":                   true,
+	"cronjobs":               true,
+}
+
+// writeVerbs are the verbs which modify the resources
+var writeVerbs = map[string]bool{
+	"create":           true,
+	"update":           true,
+	"patch":            true,
+	"delete":           true,
+	"deletecollection": true,
+}
+
+// rateLimiter throttles the requests with a token bucket per user, namespace and verb, the writes
+// to the workloads having buckets of their own
+type rateLimiter struct {
+	sync.Mutex
+	// the limits of the requests and the workload writes
+	requests, writes rateLimit
+	// the buckets keyed by the limit, user, namespace and verb
+	buckets map[string]*rateBucket
+	// the time the idle buckets were last removed
+	swept time.Time
+}
+
+// rateLimit is the rate and burst of a class of requests, a zero rate disabling the limit
+type rateLimit struct {
+	// the name of the limit
+	name string
+	// the requests per second
+	rate float64
+	// the requests permitted in a burst
+	burst int64
+}
+
+// rateBucket is the token bucket of a key
+type rateBucket struct {
+	*ratelimit.Bucket
+	// the time the bucket was last used
+	used time.Time
+}
+
+// newRateLimiter creates the rate limiter, the burst defaulting to the rate
+func newRateLimiter(rate float64, burst int, writeRate float64, writeBurst int) *rateLimiter {
+	return &rateLimiter{
+		requests: newRateLimit("requests", rate, burst),
+		writes:   newRateLimit("writes", writeRate, writeBurst),
+		buckets:  make(map[string]*rateBucket),
+		swept:    time.Now(),
+	}
+}
+
+// newRateLimit creates the limit, the burst being at least one request
+func newRateLimit(name string, rate float64, burst int) rateLimit {
+	limit := rateLimit{name: name, rate: rate, burst: int64(burst)}
+	if limit.burst <= 0 {
+		limit.burst = int64(math.Max(1, math.Ceil(rate)))
+	}
+
+	return limit
+}
+
+// rateLimitHandler throttles the requests of each user, namespace and verb, the throttled requests
+// being refused with a 429 and the time to retry after
+func (r *KubeCover) rateLimitHandler() gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		attrs := authz.NewRequestAttributes(cx.Request)
+		limit := r.limiter.requests
+		if r.limiter.writes.rate > 0 && attrs.ResourceRequest && attrs.Subresource == "" &&
+			workloadResources[attrs.Resource] && writeVerbs[attrs.Verb] {
+			limit = r.limiter.writes
+		}
+		if limit.rate <= 0 {
+			return
+		}
+
+		// step: the unauthenticated and anonymous callers are limited by address
+		caller, _, _ := net.SplitHostPort(cx.Request.RemoteAddr)
+		if identity := r.identity(cx); identity != nil && identity.GetName() != auth.AnonymousUser {
+			caller = identity.GetName()
+		}
+		wait, allowed := r.limiter.take(limit, fmt.Sprintf("%s/%s/%s/%s", limit.name, caller, attrs.Namespace, attrs.Verb))
+		if allowed {
+			return
+		}
+		rateLimitedCounter.WithLabelValues(limit.name).Inc()
+
+		seconds := int(math.Ceil(wait.Seconds()))
+		cx.Header("Retry-After", strconv.Itoa(seconds))
+		r.statusResponse(cx, http.StatusTooManyRequests, statusReasonTooManyRequests,
+			fmt.Sprintf("the rate limit of %s %s in the namespace %q has been exceeded, retry after %ds", attrs.Verb, limit.name, attrs.Namespace, seconds))
+	}
+}
+
+// take takes a token from the bucket of the key, returning the time until the next token if none is available
+func (r *rateLimiter) take(limit rateLimit, key string) (time.Duration, bool) {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+
+	// step: remove the buckets which have been idle long enough to have refilled
+	if now.Sub(r.swept) > rateLimitSweepInterval {
+		for name, x := range r.buckets {
+			if x.Available() >= x.Capacity() && now.Sub(x.used) > rateLimitSweepInterval {
+				delete(r.buckets, name)
+			}
+		}
+		r.swept = now
+	}
+
+	bucket, found := r.buckets[key]
+	if !found {
+		bucket = &rateBucket{Bucket: ratelimit.NewBucketWithRate(limit.rate, limit.burst)}
+		r.buckets[key] = bucket
+	}
+	bucket.used = now
+	if bucket.TakeAvailable(1) == 1 {
+		return 0, true
+	}
+
+	// step: the bucket is empty, the next token being at most the fill interval of a token away
+	return time.Duration(float64(time.Second) / bucket.Rate()), false
+}
@@ -0,0 +1,37 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterKeys(t *testing.T) {
+	limiter := newRateLimiter(1, 1, 0, 0)
+	if _, allowed := limiter.take(limiter.requests, "requests/alice/default/get"); !allowed {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if _, allowed := limiter.take(limiter.requests, "requests/bob/default/get"); !allowed {
+		t.Error("expected the buckets of the callers to be independent")
+	}
+	wait, allowed := limiter.take(limiter.requests, "requests/alice/default/get")
+	if allowed || wait <= 0 || wait > time.Second {
+		t.Errorf("expected the request to be refused with a wait of up to a second, got: %t, %s", allowed, wait)
+	}
+}
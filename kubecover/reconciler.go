@@ -0,0 +1,445 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gambol99/kube-cover/policy"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/types"
+)
+
+const (
+	// ReconcileActionNone only reports on the non-compliant pods
+	ReconcileActionNone = "none"
+	// ReconcileActionDelete deletes the non-compliant pods
+	ReconcileActionDelete = "delete"
+	// ReconcileActionScale scales the top-level controller owning the non-compliant pod to zero, deleting
+	// the pod should the scale not remove it
+	ReconcileActionScale = "scale"
+
+	// the annotation recording the controller which created the pod
+	createdByAnnotation = "kubernetes.io/created-by"
+	// the interval between checking the grace period of the violators
+	reconcileCheckInterval = 10 * time.Second
+	// the interval between re-listing the pods on a failure
+	reconcileRetryInterval = 5 * time.Second
+	// the maximum depth of the owner references followed to the top-level controller
+	maxOwnerDepth = 5
+)
+
+// controllerResources is the resource and default api version of the scalable controller kinds
+var controllerResources = map[string]struct{ resource, version string }{
+	"ReplicationController": {resource: "replicationcontrollers", version: "v1"},
+	"ReplicaSet":            {resource: "replicasets", version: "extensions/v1beta1"},
+	"Deployment":            {resource: "deployments", version: "extensions/v1beta1"},
+	"StatefulSet":           {resource: "statefulsets", version: "apps/v1"},
+}
+
+// ownerReference is a reference to the owner of an object
+type ownerReference struct {
+	// the api version of the owner
+	APIVersion string `json:"apiVersion"`
+	// the kind of the owner
+	Kind string `json:"kind"`
+	// the name of the owner
+	Name string `json:"name"`
+	// whether the owner is the managing controller
+	Controller *bool `json:"controller"`
+}
+
+// objectOwners is the owner references of an object
+type objectOwners struct {
+	Metadata struct {
+		// the owners of the object
+		OwnerReferences []ownerReference `json:"ownerReferences"`
+	} `json:"metadata"`
+}
+
+// watchEvent is a event from the upstream watch
+type watchEvent struct {
+	// the type of event
+	Type string `json:"type"`
+	// the object itself
+	Object json.RawMessage `json:"object"`
+}
+
+// violator is a pod found to be violating the policy
+type violator struct {
+	// the pod itself
+	pod *api.Pod
+	// the policy violation
+	violation string
+	// the time the violation was first seen
+	firstSeen time.Time
+	// whether the action has been taken
+	actioned bool
+	// the action taken
+	action string
+	// the time the action was taken
+	actionedAt time.Time
+}
+
+// reconciler watches the pods in the cluster and evaluates them against the policy
+type reconciler struct {
+	sync.Mutex
+	// the kube cover service
+	cover *KubeCover
+	// the action to take on non-compliant pods
+	action string
+	// the grace period before taking the action
+	gracePeriod time.Duration
+	// the pods currently violating the policy
+	violators map[types.UID]*violator
+}
+
+// newReconciler creates a reconciler using the upstream transport and policy of the service
+func newReconciler(cover *KubeCover, action string, gracePeriod time.Duration) (*reconciler, error) {
+	switch action {
+	case ReconcileActionNone, ReconcileActionDelete, ReconcileActionScale:
+	default:
+		return nil, fmt.Errorf("unsupported reconcile action: %s", action)
+	}
+
+	return &reconciler{
+		cover:       cover,
+		action:      action,
+		gracePeriod: gracePeriod,
+		violators:   make(map[types.UID]*violator, 0),
+	}, nil
+}
+
+// run starts watching the pods and enforcing the grace period
+func (r *reconciler) run() {
+	glog.Infof("starting the pod reconciler, action: %s, grace period: %s", r.action, r.gracePeriod)
+
+	go func() {
+		for {
+			if err := r.sync(); err != nil {
+				glog.Errorf("reconciler failed to watch the pods, error: %s", err)
+			}
+			time.Sleep(reconcileRetryInterval)
+		}
+	}()
+
+	go func() {
+		for range time.Tick(reconcileCheckInterval) {
+			r.enforce()
+		}
+	}()
+}
+
+// sync lists the pods in the cluster, evaluating each of them and then watches for changes
+func (r *reconciler) sync() error {
+	list := new(api.PodList)
+	if err := r.cover.upstreamRequest("GET", "/api/v1/pods", "", nil, list); err != nil {
+		return err
+	}
+
+	seen := make(map[types.UID]bool, len(list.Items))
+	for i := range list.Items {
+		seen[list.Items[i].UID] = true
+		r.evaluate(&list.Items[i])
+	}
+
+	// step: remove any violators which no longer exist
+	r.Lock()
+	for uid := range r.violators {
+		if !seen[uid] {
+			delete(r.violators, uid)
+		}
+	}
+	r.Unlock()
+	r.updateGauge()
+
+	return r.watch(list.ResourceVersion)
+}
+
+// watch consumes the upstream watch of pods from the resource version
+func (r *reconciler) watch(version string) error {
+	glog.V(10).Infof("watching the pods from resource version: %s", version)
+
+	stream, err := r.cover.upstreamStream("/api/v1/pods?watch=true&resourceVersion=" + version)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	decoder := json.NewDecoder(stream)
+	for {
+		event := new(watchEvent)
+		if err := decoder.Decode(event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if event.Type == "ERROR" {
+			return fmt.Errorf("watch error, %s", event.Object)
+		}
+
+		pod := new(api.Pod)
+		if err := json.Unmarshal(event.Object, pod); err != nil {
+			return err
+		}
+
+		switch event.Type {
+		case "DELETED":
+			r.Lock()
+			delete(r.violators, pod.UID)
+			r.Unlock()
+		default:
+			r.evaluate(pod)
+		}
+		r.updateGauge()
+	}
+}
+
+// evaluate checks the pod against the policy and records any violation
+func (r *reconciler) evaluate(pod *api.Pod) {
+	if pod.DeletionTimestamp != nil {
+		// step: a pod being deleted is no longer acted on
+		r.Lock()
+		if v, found := r.violators[pod.UID]; found {
+			v.pod = pod
+		}
+		r.Unlock()
+		return
+	}
+
+	err := r.cover.acl.Authorized(&policy.PolicyContext{Namespace: pod.Namespace, Cluster: r.cover.config.ClusterName}, &pod.Spec)
+
+	r.Lock()
+	defer r.Unlock()
+
+	if err == nil {
+		delete(r.violators, pod.UID)
+		return
+	}
+	if v, found := r.violators[pod.UID]; found {
+		v.pod = pod
+		return
+	}
+
+	glog.Warningf("non-compliant pod found, namespace: %s, pod: %s, violation: %s", pod.Namespace, pod.Name, err)
+	r.violators[pod.UID] = &violator{
+		pod:       pod,
+		violation: err.Error(),
+		firstSeen: time.Now(),
+	}
+	reconcileViolationCounter.WithLabelValues(pod.Namespace).Inc()
+
+	go r.recordEvent(pod, "PolicyViolation", fmt.Sprintf("pod violates the security policy, reason: %s", err))
+}
+
+// enforce takes the action on any violators which have exceeded the grace period, deleting the pods
+// which remain a grace period after their controller was scaled
+func (r *reconciler) enforce() {
+	if r.action == ReconcileActionNone {
+		return
+	}
+
+	r.Lock()
+	var expired []*violator
+	for _, v := range r.violators {
+		switch {
+		case v.pod.DeletionTimestamp != nil:
+		case !v.actioned && time.Since(v.firstSeen) > r.gracePeriod:
+			expired = append(expired, v)
+		case v.actioned && v.action == ReconcileActionScale && time.Since(v.actionedAt) > r.gracePeriod:
+			expired = append(expired, v)
+		}
+	}
+	r.Unlock()
+
+	for _, v := range expired {
+		requested := r.action
+		if v.actioned {
+			glog.Warningf("the scale did not remove the non-compliant pod, deleting instead, namespace: %s, pod: %s",
+				v.pod.Namespace, v.pod.Name)
+			requested = ReconcileActionDelete
+		}
+		action, err := r.takeAction(v.pod, requested)
+		if err != nil {
+			glog.Errorf("unable to %s the non-compliant pod, namespace: %s, pod: %s, error: %s",
+				action, v.pod.Namespace, v.pod.Name, err)
+			continue
+		}
+		glog.Warningf("performed %s on the non-compliant pod, namespace: %s, pod: %s, violation: %s",
+			action, v.pod.Namespace, v.pod.Name, v.violation)
+
+		r.Lock()
+		v.actioned = true
+		v.action = action
+		v.actionedAt = time.Now()
+		r.Unlock()
+
+		reconcileActionCounter.WithLabelValues(v.pod.Namespace, action).Inc()
+		r.recordEvent(v.pod, "PolicyEnforced",
+			fmt.Sprintf("performed %s after the grace period of %s, reason: %s", action, r.gracePeriod, v.violation))
+	}
+}
+
+// takeAction deletes the pod or scales the top-level controller owning it, returning the action taken
+func (r *reconciler) takeAction(pod *api.Pod, action string) (string, error) {
+	if action == ReconcileActionScale {
+		owner, err := r.topController(pod)
+		if err != nil {
+			return action, err
+		}
+		if owner != nil {
+			patch := map[string]interface{}{
+				"spec": map[string]interface{}{"replicas": 0},
+			}
+			uri, _ := controllerPath(owner, pod.Namespace)
+			glog.V(10).Infof("scaling the controller of the pod, namespace: %s, pod: %s, controller: %s/%s",
+				pod.Namespace, pod.Name, owner.Kind, owner.Name)
+
+			return ReconcileActionScale, r.cover.upstreamRequest("PATCH", uri, "application/merge-patch+json", patch, nil)
+		}
+		glog.V(10).Infof("pod has no scalable controller, deleting instead, namespace: %s, pod: %s", pod.Namespace, pod.Name)
+	}
+	uri := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", pod.Namespace, pod.Name)
+
+	return ReconcileActionDelete, r.cover.upstreamRequest("DELETE", uri, "", nil, nil)
+}
+
+// topController follows the controller owner references of the pod up to the top-level scalable controller,
+// i.e. the deployment owning the replica set, the created by annotation being used should the pod have none
+func (r *reconciler) topController(pod *api.Pod) (*ownerReference, error) {
+	owner, err := r.controllerOf(fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", pod.Namespace, pod.Name))
+	if err != nil {
+		return nil, err
+	}
+	if owner == nil {
+		if reference, found := podOwner(pod); found {
+			owner = &ownerReference{APIVersion: reference.APIVersion, Kind: reference.Kind, Name: reference.Name}
+		}
+	}
+
+	var top *ownerReference
+	for depth := 0; owner != nil && depth < maxOwnerDepth; depth++ {
+		uri, scalable := controllerPath(owner, pod.Namespace)
+		if !scalable {
+			break
+		}
+		top = owner
+		if owner, err = r.controllerOf(uri); err != nil {
+			return nil, err
+		}
+	}
+
+	return top, nil
+}
+
+// controllerOf retrieves the object and returns the controller owning it, if any
+func (r *reconciler) controllerOf(uri string) (*ownerReference, error) {
+	object := new(objectOwners)
+	if err := r.cover.upstreamRequest("GET", uri, "", nil, object); err != nil {
+		return nil, err
+	}
+	for i, x := range object.Metadata.OwnerReferences {
+		if x.Controller != nil && *x.Controller {
+			return &object.Metadata.OwnerReferences[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// controllerPath returns the upstream location of the controller, should it be a scalable kind
+func controllerPath(owner *ownerReference, namespace string) (string, bool) {
+	kind, found := controllerResources[owner.Kind]
+	if !found || owner.Name == "" {
+		return "", false
+	}
+	version := owner.APIVersion
+	if version == "" {
+		version = kind.version
+	}
+	prefix := "/apis/" + version
+	if version == "v1" {
+		prefix = "/api/v1"
+	}
+
+	return fmt.Sprintf("%s/namespaces/%s/%s/%s", prefix, namespace, kind.resource, owner.Name), true
+}
+
+// recordEvent creates a kubernetes event against the pod
+func (r *reconciler) recordEvent(pod *api.Pod, reason, message string) {
+	now := unversioned.Now()
+	event := &api.Event{
+		TypeMeta: unversioned.TypeMeta{Kind: "Event", APIVersion: "v1"},
+		ObjectMeta: api.ObjectMeta{
+			GenerateName: pod.Name + ".",
+			Namespace:    pod.Namespace,
+		},
+		InvolvedObject: api.ObjectReference{
+			Kind:            "Pod",
+			APIVersion:      "v1",
+			Namespace:       pod.Namespace,
+			Name:            pod.Name,
+			UID:             pod.UID,
+			ResourceVersion: pod.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Source:         api.EventSource{Component: "kube-cover"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	uri := fmt.Sprintf("/api/v1/namespaces/%s/events", pod.Namespace)
+	if err := r.cover.upstreamRequest("POST", uri, "application/json", event, nil); err != nil {
+		glog.Errorf("unable to record the event for pod, namespace: %s, pod: %s, error: %s", pod.Namespace, pod.Name, err)
+	}
+}
+
+// updateGauge updates the number of non-compliant pods
+func (r *reconciler) updateGauge() {
+	r.Lock()
+	defer r.Unlock()
+	reconcileNonCompliantGauge.Set(float64(len(r.violators)))
+}
+
+// podOwner extracts the controller which created the pod
+func podOwner(pod *api.Pod) (*api.ObjectReference, bool) {
+	annotation, found := pod.Annotations[createdByAnnotation]
+	if !found {
+		return nil, false
+	}
+	reference := new(api.SerializedReference)
+	if err := json.Unmarshal([]byte(annotation), reference); err != nil {
+		glog.Errorf("unable to decode the created by annotation, pod: %s, error: %s", pod.Name, err)
+		return nil, false
+	}
+	if reference.Reference.Namespace == "" {
+		reference.Reference.Namespace = pod.Namespace
+	}
+
+	return &reference.Reference, true
+}
@@ -0,0 +1,181 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+// newTestReconciler creates a reconciler against an upstream serving the objects, recording the requests made
+func newTestReconciler(t *testing.T, action string, objects map[string]string) (*reconciler, func() []string) {
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		requests = append(requests, req.Method+" "+req.URL.Path)
+		lock.Unlock()
+
+		switch req.Method {
+		case "GET":
+			object, found := objects[req.URL.Path]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"kind":"Status","status":"Failure","reason":"NotFound","code":404}`)
+				return
+			}
+			fmt.Fprint(w, object)
+		default:
+			fmt.Fprint(w, "{}")
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	upstream, _ := url.Parse(server.URL)
+	cover := &KubeCover{
+		config:      &Config{},
+		client:      server.Client(),
+		upstream:    upstream,
+		credentials: &upstreamCredentials{},
+	}
+	reconciler, err := newReconciler(cover, action, time.Minute)
+	if err != nil {
+		t.Fatalf("unable to create the reconciler, error: %s", err)
+	}
+
+	return reconciler, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+func TestReconcilerTakeAction(t *testing.T) {
+	owned := func(apiVersion, kind, name string) string {
+		return fmt.Sprintf(`{"metadata":{"ownerReferences":[{"apiVersion":%q,"kind":%q,"name":%q,"controller":true}]}}`, apiVersion, kind, name)
+	}
+	cases := []struct {
+		objects     map[string]string
+		annotations map[string]string
+		action      string
+		request     string
+	}{
+		{
+			objects: map[string]string{
+				"/api/v1/namespaces/default/pods/web-1":                 owned("apps/v1", "ReplicaSet", "web-5d8f"),
+				"/apis/apps/v1/namespaces/default/replicasets/web-5d8f": owned("apps/v1", "Deployment", "web"),
+				"/apis/apps/v1/namespaces/default/deployments/web":      `{"metadata":{}}`,
+			},
+			action:  ReconcileActionScale,
+			request: "PATCH /apis/apps/v1/namespaces/default/deployments/web",
+		},
+		{
+			objects: map[string]string{
+				"/api/v1/namespaces/default/pods/web-1":                 owned("apps/v1", "ReplicaSet", "web-5d8f"),
+				"/apis/apps/v1/namespaces/default/replicasets/web-5d8f": `{"metadata":{"ownerReferences":[{"kind":"Deployment","name":"web"}]}}`,
+			},
+			action:  ReconcileActionScale,
+			request: "PATCH /apis/apps/v1/namespaces/default/replicasets/web-5d8f",
+		},
+		{
+			objects: map[string]string{
+				"/api/v1/namespaces/default/pods/web-1":                    `{"metadata":{}}`,
+				"/api/v1/namespaces/default/replicationcontrollers/web-rc": `{"metadata":{}}`,
+			},
+			annotations: map[string]string{
+				createdByAnnotation: `{"kind":"SerializedReference","reference":{"kind":"ReplicationController","name":"web-rc","apiVersion":"v1"}}`,
+			},
+			action:  ReconcileActionScale,
+			request: "PATCH /api/v1/namespaces/default/replicationcontrollers/web-rc",
+		},
+		{
+			objects: map[string]string{
+				"/api/v1/namespaces/default/pods/web-1": owned("apps/v1", "DaemonSet", "agent"),
+			},
+			action:  ReconcileActionDelete,
+			request: "DELETE /api/v1/namespaces/default/pods/web-1",
+		},
+		{
+			objects: map[string]string{
+				"/api/v1/namespaces/default/pods/web-1": `{"metadata":{}}`,
+			},
+			action:  ReconcileActionDelete,
+			request: "DELETE /api/v1/namespaces/default/pods/web-1",
+		},
+	}
+	for i, c := range cases {
+		reconciler, requests := newTestReconciler(t, ReconcileActionScale, c.objects)
+		pod := &api.Pod{ObjectMeta: api.ObjectMeta{Name: "web-1", Namespace: "default", Annotations: c.annotations}}
+		action, err := reconciler.takeAction(pod, ReconcileActionScale)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if action != c.action {
+			t.Errorf("case %d: expected the action: %s, got: %s", i, c.action, action)
+		}
+		made := requests()
+		if last := made[len(made)-1]; last != c.request {
+			t.Errorf("case %d: expected the request: %s, got: %v", i, c.request, made)
+		}
+	}
+}
+
+func TestReconcilerScaleFallback(t *testing.T) {
+	reconciler, requests := newTestReconciler(t, ReconcileActionScale, map[string]string{})
+	pod := &api.Pod{ObjectMeta: api.ObjectMeta{Name: "web-1", Namespace: "default", UID: "1"}}
+	reconciler.violators[pod.UID] = &violator{
+		pod:        pod,
+		firstSeen:  time.Now().Add(-time.Hour),
+		actioned:   true,
+		action:     ReconcileActionScale,
+		actionedAt: time.Now().Add(-2 * time.Minute),
+	}
+
+	reconciler.enforce()
+	deleted := false
+	for _, x := range requests() {
+		if x == "DELETE /api/v1/namespaces/default/pods/web-1" {
+			deleted = true
+		}
+	}
+	if !deleted {
+		t.Errorf("expected the pod to be deleted once the scale did not hold, requests: %v", requests())
+	}
+	if v := reconciler.violators[pod.UID]; v.action != ReconcileActionDelete {
+		t.Errorf("expected the action to be delete, got: %s", v.action)
+	}
+
+	// step: a pod being deleted by the scale is left alone
+	reconciler, requests = newTestReconciler(t, ReconcileActionScale, map[string]string{})
+	now := unversioned.Now()
+	pod.DeletionTimestamp = &now
+	reconciler.violators[pod.UID] = &violator{pod: pod, actioned: true, action: ReconcileActionScale, actionedAt: time.Now().Add(-2 * time.Minute)}
+	reconciler.enforce()
+	if made := requests(); len(made) != 0 {
+		t.Errorf("expected no requests for a pod being deleted, got: %v", made)
+	}
+}
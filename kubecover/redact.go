@@ -0,0 +1,271 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gambol99/kube-cover/authz"
+	"github.com/gambol99/kube-cover/policy"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/auth/user"
+)
+
+// the value replacing the redacted environment values
+const redactedValue = "**redacted**"
+
+// contextKey is the type of the keys in the request context
+type contextKey string
+
+// the key of the redactor in the request context
+const redactorContextKey contextKey = "kubecover.redactor"
+
+// the annotation of kubectl apply holding the configuration last applied
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// redactor removes the sensitive values from the objects returned to a user, the rules being
+// selected by the namespace of each object
+type redactor struct {
+	// the policy controller
+	acl policy.Controller
+	// the user reading the objects
+	identity user.Info
+	// the namespace of the request
+	namespace string
+	// the cluster of the request
+	cluster string
+	// the rules found per namespace
+	rules map[string]*policy.RedactionPolicy
+}
+
+// redactionHandler marks the requests whose responses may be redacted, ensuring the responses
+// are returned as uncompressed json
+func (r *KubeCover) redactionHandler() gin.HandlerFunc {
+	return func(cx *gin.Context) {
+		attrs := authz.NewRequestAttributes(cx.Request)
+		if !attrs.ResourceRequest || isUpgradedConnection(cx.Request) {
+			return
+		}
+		// step: the responses of the nodes and services proxied to are not api objects
+		if _, _, _, _, found := apiProxyTarget(cx.Request.URL.Path); found {
+			return
+		}
+		redact := &redactor{
+			acl:       r.acl,
+			identity:  r.identity(cx),
+			namespace: attrs.Namespace,
+			cluster:   r.config.ClusterName,
+			rules:     make(map[string]*policy.RedactionPolicy),
+		}
+		// step: the objects of a namespaced request or a named object can only come from the one namespace
+		if (attrs.Namespace != "" || attrs.Name != "") && redact.rulesFor(attrs.Namespace) == nil {
+			return
+		}
+
+		cx.Request.Header.Set("Accept", "application/json")
+		cx.Request.Header.Del("Accept-Encoding")
+		cx.Request = cx.Request.WithContext(context.WithValue(cx.Request.Context(), redactorContextKey, redact))
+	}
+}
+
+// redactResponse redacts the objects in the response, the watch events being redacted as they are streamed
+func (r *KubeCover) redactResponse(resp *http.Response) error {
+	redact, found := resp.Request.Context().Value(redactorContextKey).(*redactor)
+	if !found {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" {
+		return nil
+	}
+
+	// step: are we watching?
+	if authz.NewRequestAttributes(resp.Request).Verb == "watch" {
+		resp.Body = redact.stream(resp.Body)
+		return nil
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	object := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		glog.Errorf("unable to decode the response for redaction, uri: %s, error: %s", resp.Request.URL.Path, err)
+		return err
+	}
+	redact.object(object, "")
+
+	if content, err = json.Marshal(object); err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(content))
+	resp.ContentLength = int64(len(content))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(content)))
+
+	return nil
+}
+
+// redactedStream is the redacted watch events read from the upstream body
+type redactedStream struct {
+	*io.PipeReader
+	// the upstream body
+	body io.Closer
+}
+
+// Close closes the pipe and the upstream body
+func (r *redactedStream) Close() error {
+	r.PipeReader.Close()
+	return r.body.Close()
+}
+
+// stream redacts the objects of the watch events read from the body
+func (r *redactor) stream(body io.ReadCloser) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		defer body.Close()
+		decoder := json.NewDecoder(body)
+		decoder.UseNumber()
+		encoder := json.NewEncoder(writer)
+		for {
+			event := make(map[string]interface{})
+			if err := decoder.Decode(&event); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				writer.CloseWithError(err)
+				return
+			}
+			if object, found := event["object"].(map[string]interface{}); found {
+				r.object(object, "")
+			}
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+		}
+	}()
+
+	return &redactedStream{PipeReader: reader, body: body}
+}
+
+// object redacts the object, the items of the lists taking the kind of the list
+func (r *redactor) object(object map[string]interface{}, kind string) {
+	if x, found := object["kind"].(string); found && x != "" {
+		kind = x
+	}
+	if strings.HasSuffix(kind, "List") {
+		items, _ := object["items"].([]interface{})
+		for _, x := range items {
+			if item, found := x.(map[string]interface{}); found {
+				r.object(item, strings.TrimSuffix(kind, "List"))
+			}
+		}
+		return
+	}
+
+	metadata, _ := object["metadata"].(map[string]interface{})
+	namespace, _ := metadata["namespace"].(string)
+	if namespace == "" {
+		namespace = r.namespace
+	}
+	rules := r.rulesFor(namespace)
+	if rules == nil {
+		return
+	}
+
+	// step: strip the annotations
+	if annotations, found := metadata["annotations"].(map[string]interface{}); found {
+		for name := range annotations {
+			if rules.RedactsAnnotation(name) {
+				delete(annotations, name)
+			}
+		}
+	}
+	// step: the last applied configuration holds a copy of the object, i.e. the data of a secret or the env values
+	if (kind == "Secret" && rules.Secrets) || len(rules.Env) > 0 {
+		if annotations, found := metadata["annotations"].(map[string]interface{}); found {
+			delete(annotations, lastAppliedAnnotation)
+		}
+	}
+	// step: blank the data of the secrets
+	if kind == "Secret" && rules.Secrets {
+		for _, field := range []string{"data", "stringData"} {
+			if data, found := object[field].(map[string]interface{}); found {
+				for name := range data {
+					data[name] = ""
+				}
+			}
+		}
+	}
+	// step: replace the environment values of the containers
+	if len(rules.Env) > 0 {
+		redactEnv(object["spec"], rules)
+	}
+}
+
+// rulesFor returns the redaction applied to the objects in the namespace, nil if none
+func (r *redactor) rulesFor(namespace string) *policy.RedactionPolicy {
+	if rules, found := r.rules[namespace]; found {
+		return rules
+	}
+	rules, _ := r.acl.Redaction(&policy.PolicyContext{Namespace: namespace, Cluster: r.cluster, User: r.identity})
+	r.rules[namespace] = rules
+
+	return rules
+}
+
+// redactEnv walks the spec replacing the matching values in the env of the containers
+func redactEnv(value interface{}, rules *policy.RedactionPolicy) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, x := range v {
+			if key != "env" {
+				redactEnv(x, rules)
+				continue
+			}
+			variables, _ := x.([]interface{})
+			for _, variable := range variables {
+				env, found := variable.(map[string]interface{})
+				if !found {
+					continue
+				}
+				name, _ := env["name"].(string)
+				value, found := env["value"].(string)
+				if found && rules.RedactsEnv(name, value) {
+					env["value"] = redactedValue
+				}
+			}
+		}
+	case []interface{}:
+		for _, x := range v {
+			redactEnv(x, rules)
+		}
+	}
+}
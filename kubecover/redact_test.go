@@ -0,0 +1,113 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gambol99/kube-cover/policy"
+)
+
+const redactionPolicies = `{
+  "kind": "PodSecurityPolicyList",
+  "items": [
+    {
+      "kind": "PodSecurityPolicy",
+      "namespaces": ["secrets"],
+      "spec": {"redaction": {"secrets": true, "annotations": ["example.com/credentials"]}}
+    },
+    {
+      "kind": "PodSecurityPolicy",
+      "namespaces": ["env"],
+      "spec": {"redaction": {"env": ["(?i)password"]}}
+    }
+  ]
+}`
+
+// newTestController creates a policy controller from the policies
+func newTestController(t *testing.T, policies string) policy.Controller {
+	dir, err := ioutil.TempDir("", "kubecover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "policies.json")
+	if err := ioutil.WriteFile(filename, []byte(policies), 0600); err != nil {
+		t.Fatal(err)
+	}
+	acl, err := policy.NewController(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return acl
+}
+
+func TestRedactorObject(t *testing.T) {
+	acl := newTestController(t, redactionPolicies)
+	cases := []struct {
+		name     string
+		object   string
+		expected string
+	}{
+		{
+			name:     "secret",
+			object:   `{"kind":"Secret","metadata":{"namespace":"secrets","annotations":{"a":"b","example.com/credentials":"x","kubectl.kubernetes.io/last-applied-configuration":"{\"data\":{\"key\":\"c2VjcmV0\"}}"}},"data":{"key":"c2VjcmV0"},"stringData":{"other":"secret"}}`,
+			expected: `{"kind":"Secret","metadata":{"namespace":"secrets","annotations":{"a":"b"}},"data":{"key":""},"stringData":{"other":""}}`,
+		},
+		{
+			name:     "secret list",
+			object:   `{"kind":"SecretList","items":[{"metadata":{"namespace":"secrets"},"data":{"key":"c2VjcmV0"}}]}`,
+			expected: `{"kind":"SecretList","items":[{"metadata":{"namespace":"secrets"},"data":{"key":""}}]}`,
+		},
+		{
+			name:     "secret in a namespace without redaction",
+			object:   `{"kind":"Secret","metadata":{"namespace":"other","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{}"}},"data":{"key":"c2VjcmV0"}}`,
+			expected: `{"kind":"Secret","metadata":{"namespace":"other","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{}"}},"data":{"key":"c2VjcmV0"}}`,
+		},
+		{
+			name:     "pod env",
+			object:   `{"kind":"Pod","metadata":{"namespace":"env","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{}"}},"spec":{"containers":[{"env":[{"name":"PASSWORD","value":"secret"},{"name":"HOME","value":"/root"}]}]}}`,
+			expected: `{"kind":"Pod","metadata":{"namespace":"env","annotations":{}},"spec":{"containers":[{"env":[{"name":"PASSWORD","value":"` + redactedValue + `"},{"name":"HOME","value":"/root"}]}]}}`,
+		},
+		{
+			name:     "configmap in the secrets namespace",
+			object:   `{"kind":"ConfigMap","metadata":{"namespace":"secrets","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{}"}},"data":{"key":"value"}}`,
+			expected: `{"kind":"ConfigMap","metadata":{"namespace":"secrets","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{}"}},"data":{"key":"value"}}`,
+		},
+	}
+	for _, c := range cases {
+		var object, expected map[string]interface{}
+		if err := json.Unmarshal([]byte(c.object), &object); err != nil {
+			t.Fatalf("case: %s, invalid object, %s", c.name, err)
+		}
+		if err := json.Unmarshal([]byte(c.expected), &expected); err != nil {
+			t.Fatalf("case: %s, invalid expected object, %s", c.name, err)
+		}
+		redactor := &redactor{acl: acl, rules: make(map[string]*policy.RedactionPolicy)}
+		redactor.object(object, "")
+		if !reflect.DeepEqual(object, expected) {
+			t.Errorf("case: %s, expected: %v, got: %v", c.name, expected, object)
+		}
+	}
+}
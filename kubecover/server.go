@@ -18,132 +18,366 @@ limitations under the License.
 package kubecover
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net/http/httputil"
 	"net/url"
 
+	"github.com/gambol99/kube-cover/auth"
+	"github.com/gambol99/kube-cover/authz"
 	"github.com/gambol99/kube-cover/policy"
 
-	"bytes"
-	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
-	"io/ioutil"
+	"github.com/prometheus/client_golang/prometheus"
 	"net/http"
-	"strings"
-	"sync"
 )
 
 // NewCover creates a new kube cover service
-func NewCover(upstream, policyPath string) (*KubeCover, error) {
+func NewCover(config *Config) (*KubeCover, error) {
+	// step: load the upstream credentials
+	credentials, err := loadUpstreamCredentials(config)
+	if err != nil {
+		return nil, err
+	}
+	upstreamTLS, err := credentials.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if credentials.server != "" {
+		config.UpstreamURL = credentials.server
+	}
+	if config.Impersonate {
+		if config.Mode != ModeProxy && config.Mode != "" {
+			return nil, fmt.Errorf("impersonation is only supported in proxy mode")
+		}
+		if credentials.token == "" && len(credentials.certData) <= 0 {
+			return nil, fmt.Errorf("impersonation requires the upstream credentials of the proxy")
+		}
+	}
+
 	// step: parse and validate the upstreams
-	location, err := url.Parse(upstream)
+	if config.UpstreamBalance == "" {
+		config.UpstreamBalance = BalanceRoundRobin
+	}
+	upstreams, err := newUpstreamPool(config.UpstreamURL, config.UpstreamBalance, config.UpstreamFailureThreshold, config.UpstreamBreakerTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("invalid upstrem url, %s", err)
+		return nil, err
+	}
+	if config.Mode == ModeDocker {
+		upstreams.endpoints = []*upstreamEndpoint{{location: &url.URL{Scheme: "unix", Path: config.DockerSocket}}}
 	}
 
 	service := new(KubeCover)
-	service.upstream = location
+	service.config = config
+	service.upstreams = upstreams
+	service.upstream = upstreams.endpoints[0].location
+	service.credentials = credentials
+	service.upstreamTLS = upstreamTLS
+	// step: the proxied connections only carry the credentials of the proxy when impersonating or in front of
+	// the kubelet, the authorization point, otherwise the credentials of the caller are passed through
+	service.proxyCredentials = config.Impersonate || config.Mode == ModeKubelet
+	service.proxyTLS = upstreamTLS
+	if !service.proxyCredentials {
+		service.proxyTLS = upstreamTLS.Clone()
+		service.proxyTLS.Certificates = nil
+	}
+	service.upgrades = &sessionCounter{sessions: make(map[string]int)}
 
-	glog.Infof("kubernetes api: %s", service.upstream.String())
+	for _, x := range upstreams.endpoints {
+		glog.Infof("kubernetes api: %s", x.location.String())
+	}
 
 	// step: create the policy controller
-	acl, err := policy.NewController(policyPath)
+	acl, err := policy.NewController(config.PolicyFile)
 	if err != nil {
 		return nil, err
 	}
 	service.acl = acl
 
+	// step: create the shadow policy controller if required
+	if config.ShadowPolicyFile != "" {
+		glog.Infof("evaluating the shadow policy file: %s", config.ShadowPolicyFile)
+		shadow, err := policy.NewController(config.ShadowPolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		service.shadow = shadow
+	}
+
+	// step: create the rate limiter if required
+	if config.RateLimit > 0 || config.WriteRateLimit > 0 {
+		if config.Mode != ModeProxy && config.Mode != "" {
+			return nil, fmt.Errorf("the rate limits are only supported in proxy mode")
+		}
+		service.limiter = newRateLimiter(config.RateLimit, config.RateLimitBurst, config.WriteRateLimit, config.WriteRateLimitBurst)
+	}
+
+	// step: create the discovery cache if required
+	if config.Mode == ModeProxy && config.DiscoveryCacheTTL > 0 {
+		service.discovery = newDiscoveryCache(config.DiscoveryCacheTTL)
+	}
+
+	// step: create and setup the reverse proxy
+	target := service.upstream
+	service.transport = buildTransport(upstreamTLS)
+	proxyTransport := buildTransport(service.proxyTLS)
+	if config.Mode == ModeDocker {
+		target = &url.URL{Scheme: "http", Host: "docker"}
+		service.transport = buildUnixTransport(config.DockerSocket)
+		proxyTransport = service.transport
+	}
+	service.client = &http.Client{Transport: service.pooledTransport(service.transport)}
+	service.proxy = httputil.NewSingleHostReverseProxy(target)
+	service.proxy.Transport = service.pooledTransport(proxyTransport)
+	director := service.proxy.Director
+	service.proxy.Director = func(req *http.Request) {
+		director(req)
+		if service.proxyCredentials {
+			service.credentials.setCredentials(req)
+		}
+	}
+	service.proxy.ModifyResponse = service.modifyResponse
+	service.proxy.ErrorHandler = proxyError
+	// step: the streaming responses are flushed as they are written
+	streamer := *service.proxy
+	streamer.FlushInterval = -1
+	service.streamer = &streamer
+
+	// step: create the authenticators for the callers
+	if service.authenticator, err = service.newAuthenticator(); err != nil {
+		return nil, err
+	}
+	if config.Impersonate && service.authenticator == nil {
+		return nil, fmt.Errorf("impersonation requires the callers to be authenticated")
+	}
+
+	// step: create the authorizer for the requests if required
+	if config.RoleFile != "" {
+		if config.Mode != ModeProxy && config.Mode != "" {
+			return nil, fmt.Errorf("the role file is only supported in proxy mode")
+		}
+		if service.authenticator == nil {
+			return nil, fmt.Errorf("the role file requires the callers to be authenticated")
+		}
+		if service.authorizer, err = authz.NewRoleAuthorizer(config.RoleFile); err != nil {
+			return nil, err
+		}
+	}
+
+	// step: create the gin router
+	switch config.Mode {
+	case ModeAdmission:
+		service.engine = service.admissionRouter()
+	case ModeKubelet:
+		if service.authenticator == nil || config.AnonymousAuth {
+			return nil, fmt.Errorf("the kubelet mode requires the callers to be authenticated")
+		}
+		allowed, err := kubeletAllowedPaths(config.KubeletAllowedPaths)
+		if err != nil {
+			return nil, err
+		}
+		service.engine = service.kubeletRouter(allowed)
+	case ModeDocker:
+		if config.Reconcile {
+			return nil, fmt.Errorf("the reconciler is not supported in docker mode")
+		}
+		service.engine = service.dockerRouter()
+	case ModeProxy, "":
+		service.engine = service.proxyRouter()
+	default:
+		return nil, fmt.Errorf("unsupported service mode: %s", config.Mode)
+	}
+
+	// step: create the pod reconciler if required
+	if config.Reconcile {
+		service.reconciler, err = newReconciler(service, config.ReconcileAction, config.ReconcileGracePeriod)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return service, nil
+}
+
+// newAuthenticator creates the authenticator from the configured methods, nil if none are
+func (r *KubeCover) newAuthenticator() (auth.Authenticator, error) {
+	var authenticators []auth.Authenticator
+	config := r.config
+
+	if config.ClientCA != "" {
+		authenticators = append(authenticators, certificateAuthenticator{})
+	}
+	if config.TokenAuthFile != "" {
+		authenticator, err := auth.NewTokenFileAuthenticator(config.TokenAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if config.BasicAuthFile != "" {
+		authenticator, err := auth.NewBasicAuthenticator(config.BasicAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if config.OIDCIssuerURL != "" {
+		authenticator, err := auth.NewOIDCAuthenticator(auth.OIDCOptions{
+			IssuerURL:     config.OIDCIssuerURL,
+			ClientID:      config.OIDCClientID,
+			JWKS:          config.OIDCJWKS,
+			UsernameClaim: config.OIDCUsernameClaim,
+			GroupsClaim:   config.OIDCGroupsClaim,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	// step: the token review is last as it requires a call to the upstream
+	if config.TokenReview {
+		if config.Mode != ModeProxy && config.Mode != "" {
+			return nil, fmt.Errorf("the token review is only supported in proxy mode")
+		}
+		authenticators = append(authenticators, auth.NewTokenReviewAuthenticator(
+			&upstreamTokenReviewer{cover: r}, config.TokenReviewTTL, config.TokenReviewNegativeTTL))
+	}
+	if len(authenticators) <= 0 {
+		return nil, nil
+	}
+
+	return auth.NewUnionAuthenticator(authenticators...), nil
+}
+
+// proxyRouter creates the router filtering the requests proxied to the upstream
+func (r *KubeCover) proxyRouter() *gin.Engine {
 	// step: create the gin router
 	router := gin.Default()
-	router.Use(service.proxyHandler())
+	if r.authenticator != nil {
+		router.Use(r.authenticationHandler())
+	}
+	router.Use(r.impersonationHandler())
+	if r.limiter != nil {
+		router.Use(r.rateLimitHandler())
+	}
+	if r.authorizer != nil {
+		router.Use(r.authorizationHandler())
+	}
+	if r.discovery != nil {
+		router.Use(r.discoveryHandler())
+	}
+	router.Use(r.apiProxyHandler(), r.redactionHandler(), r.proxyHandler())
 
 	// step: handle operations related to replication controllers]
 	replicationEndpoint := "/api/v1/namespaces/:namespace/replicationcontrollers"
 	replicationUpdateEndpoint := "/api/v1/namespaces/:namespace/replicationcontrollers/:name"
-	router.POST(replicationEndpoint, service.handleReplicationController)
-	router.PATCH(replicationUpdateEndpoint, service.handleReplicationController)
-	router.PUT(replicationUpdateEndpoint, service.handleReplicationController)
+	router.POST(replicationEndpoint, r.handleReplicationController)
+	router.PATCH(replicationUpdateEndpoint, r.handleReplicationController)
+	router.PUT(replicationUpdateEndpoint, r.handleReplicationController)
 
 	// step: handle the post operations
 	podEndpoint := "/api/v1/namespaces/:namespace/pods"
 	podUpdate := "/api/v1/namespaces/:namespace/pods/:name"
-	router.POST(podEndpoint, service.handlePods)
-	router.PATCH(podUpdate, service.handlePods)
-	router.PUT(podUpdate, service.handlePods)
+	router.POST(podEndpoint, r.handlePods)
+	router.PATCH(podUpdate, r.handlePods)
+	router.PUT(podUpdate, r.handlePods)
 
-	service.engine = router
-
-	// step: create and setup the reverse proxy
-	service.proxy = httputil.NewSingleHostReverseProxy(service.upstream)
-	service.proxy.Transport = buildTransport()
+	// step: handle the streaming operations on the pods
+	podExec := "/api/v1/namespaces/:namespace/pods/:name/exec"
+	podAttach := "/api/v1/namespaces/:namespace/pods/:name/attach"
+	podPortForward := "/api/v1/namespaces/:namespace/pods/:name/portforward"
+	router.GET(podExec, r.handleStream(policy.StreamExec))
+	router.POST(podExec, r.handleStream(policy.StreamExec))
+	router.GET(podAttach, r.handleStream(policy.StreamAttach))
+	router.POST(podAttach, r.handleStream(policy.StreamAttach))
+	router.GET(podPortForward, r.handleStream(policy.StreamPortForward))
+	router.POST(podPortForward, r.handleStream(policy.StreamPortForward))
+	router.GET("/api/v1/namespaces/:namespace/pods/:name/log", r.handleLogs)
 
-	return service, nil
+	return router
 }
 
-// decodeInput decodes the json payload
-func (r *KubeCover) decodeInput(req *http.Request, data interface{}) (string, error) {
-	// step: read in the content payload
-	content, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		glog.Errorf("unable to read in the content, error: %s", err)
-		return "", err
-	}
-	defer func() {
-		// we need to set the content back
-		req.Body = ioutil.NopCloser(bytes.NewReader(content))
-	}()
+// admissionRouter creates the router handling the admission reviews from the api
+func (r *KubeCover) admissionRouter() *gin.Engine {
+	router := gin.Default()
+	router.POST("/validate", r.handleAdmission)
+	router.POST("/mutate", r.handleAdmission)
 
-	rdr := strings.NewReader(string(content))
+	return router
+}
 
-	// step: decode the json
-	err = json.NewDecoder(rdr).Decode(data)
-	if err != nil {
-		glog.Errorf("unable to decode the request body, error: %s", err)
-		return "", err
+// pooledTransport balances the requests over the transport between the upstreams
+func (r *KubeCover) pooledTransport(transport http.RoundTripper) *upstreamTransport {
+	return &upstreamTransport{
+		pool:            r.upstreams,
+		transport:       transport,
+		responseTimeout: r.config.UpstreamResponseTimeout,
+		requestTimeout:  r.config.UpstreamRequestTimeout,
+		streamTimeout:   r.config.UpstreamStreamTimeout,
 	}
-
-	return string(content), nil
 }
 
 // Run start the gin engine and begins serving content
 func (r *KubeCover) Run(address, certFile, privateFile string) error {
-	if err := r.engine.RunTLS(address, certFile, privateFile); err != nil {
-		return err
-	}
-
-	return nil
-}
+	serveMetrics(r.config.MetricsBind)
+	r.start()
 
-// tryUpdateConnection attempt to upgrade the connection to a http pdy stream
-func (r *KubeCover) tryUpdateConnection(cx *gin.Context) error {
-	// step: dial the kubernetes endpoint
-	tlsConn, err := tryDialEndpoint(r.upstream)
+	server, err := newServer(r.config, streamingHandler(r.engine), address)
 	if err != nil {
 		return err
 	}
-	defer tlsConn.Close()
 
-	// step: we need to hijack the underlining client connection
-	clientConn, _, err := cx.Writer.(http.Hijacker).Hijack()
-	if err != nil {
+	// step: the docker mode listens on a unix socket
+	if r.config.Mode == ModeDocker {
+		return serveUnix(server, address)
+	}
 
+	return server.ListenAndServeTLS(certFile, privateFile)
+}
+
+// start runs the background tasks of the service
+func (r *KubeCover) start() {
+	// step: health check the upstreams if there's a choice
+	if len(r.upstreams.endpoints) > 1 {
+		go r.checkUpstreams(r.config.UpstreamHealthInterval)
 	}
-	defer clientConn.Close()
 
-	// step: write the request to upstream
-	if err = cx.Request.Write(tlsConn); err != nil {
-		return err
+	// step: start the pod reconciler if required
+	if r.reconciler != nil {
+		r.reconciler.run()
 	}
+}
 
-	// step: copy the date between client and upstream endpoint
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go transferBytes(tlsConn, clientConn, &wg)
-	go transferBytes(clientConn, tlsConn, &wg)
-	wg.Wait()
+// newServer creates the http server of the handler, requesting the client certificates if required
+func newServer(config *Config, handler http.Handler, address string) (*http.Server, error) {
+	server := &http.Server{
+		Addr:         address,
+		Handler:      handler,
+		WriteTimeout: config.ServerWriteTimeout,
+		TLSConfig:    &tls.Config{},
+	}
+	if config.ClientCA != "" {
+		pool, err := loadCertificatePool(config.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		server.TLSConfig.ClientCAs = pool
+	}
 
-	glog.V(10).Infof("closing the http stream from upstream and client")
+	return server, nil
+}
 
-	return nil
+// serveMetrics exposes the prometheus metrics if required
+func serveMetrics(address string) {
+	if address == "" {
+		return
+	}
+	go func() {
+		glog.Infof("exposing the prometheus metrics on: %s", address)
+		if err := http.ListenAndServe(address, prometheus.Handler()); err != nil {
+			glog.Errorf("unable to serve the metrics, error: %s", err)
+		}
+	}()
 }
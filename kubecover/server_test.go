@@ -0,0 +1,106 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed client certificate and private key to the directory
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate the key, error: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kube-cover"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create the certificate, error: %s", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unable to encode the key, error: %s", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestNewCoverProxyCredentials(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+	tokenFile := filepath.Join(dir, "tokens.csv")
+	if err := ioutil.WriteFile(tokenFile, []byte("token,admin,1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		mode        string
+		url         string
+		impersonate bool
+		credentials bool
+	}{
+		{mode: ModeKubelet, url: "https://127.0.0.1:10250", credentials: true},
+		{mode: ModeProxy, url: "https://127.0.0.1:6443"},
+		{mode: ModeProxy, url: "https://127.0.0.1:6443", impersonate: true, credentials: true},
+	}
+	for _, c := range cases {
+		service, err := NewCover(&Config{
+			Mode:                c.mode,
+			UpstreamURL:         c.url,
+			UpstreamClientCert:  certFile,
+			UpstreamClientKey:   keyFile,
+			Impersonate:         c.impersonate,
+			TokenAuthFile:       tokenFile,
+			KubeletAllowedPaths: "/healthz",
+			PolicyFile:          "../tests/policies.json",
+		})
+		if err != nil {
+			t.Errorf("mode: %s, unable to create the service, error: %s", c.mode, err)
+			continue
+		}
+		if service.proxyCredentials != c.credentials {
+			t.Errorf("mode: %s, impersonate: %t, expected the proxy credentials: %t", c.mode, c.impersonate, c.credentials)
+		}
+		if presented := len(service.proxyTLS.Certificates) > 0; presented != c.credentials {
+			t.Errorf("mode: %s, impersonate: %t, expected the client certificate presented: %t", c.mode, c.impersonate, c.credentials)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gambol99/kube-cover/policy"
+	"github.com/gambol99/kube-cover/sessions"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// sessionLimits are the limits applied to an upgraded session
+type sessionLimits struct {
+	// the user holding the session
+	user string
+	// the maximum concurrent sessions of the user, zero being unlimited
+	maxSessions int
+	// the maximum duration of the session
+	maxDuration time.Duration
+	// the idle timeout of the session
+	idleTimeout time.Duration
+}
+
+// sessionCounter counts the upgraded sessions held by each user
+type sessionCounter struct {
+	sync.Mutex
+	// the open sessions of each user
+	sessions map[string]int
+}
+
+// prepareSession applies the limits and recording of the policy to the streaming session
+func (r *KubeCover) prepareSession(cx *gin.Context, operation policy.StreamOperation, pod, container string) {
+	r.sessionLimits(cx)
+	r.sessionRecording(cx, operation, pod, container)
+}
+
+// sessionLimits applies the session limits of the policy, which can only tighten the default timeouts
+func (r *KubeCover) sessionLimits(cx *gin.Context) {
+	context, err := r.deriveContext(cx)
+	if err != nil {
+		return
+	}
+	limits := &sessionLimits{
+		maxDuration: r.config.UpgradeMaxDuration,
+		idleTimeout: r.config.UpgradeIdleTimeout,
+	}
+	if context.User != nil {
+		limits.user = context.User.GetName()
+	}
+	if p, found := r.acl.Sessions(context); found {
+		limits.maxSessions = p.MaxSessions
+		limits.maxDuration, limits.idleTimeout = p.Limits(limits.maxDuration, limits.idleTimeout)
+	}
+
+	cx.Set(limitsContextKey, limits)
+}
+
+// acquire adds a session for the user, refusing it if the user already holds the maximum
+func (r *sessionCounter) acquire(user string, maximum int) bool {
+	r.Lock()
+	defer r.Unlock()
+	if maximum > 0 && r.sessions[user] >= maximum {
+		return false
+	}
+	r.sessions[user]++
+
+	return true
+}
+
+// release removes a session of the user
+func (r *sessionCounter) release(user string) {
+	r.Lock()
+	defer r.Unlock()
+	if r.sessions[user]--; r.sessions[user] <= 0 {
+		delete(r.sessions, user)
+	}
+}
+
+// sessionRecording marks the exec and attach sessions selected by the policy for recording, the sessions
+// being recorded if the labels of the namespace cannot be retrieved
+func (r *KubeCover) sessionRecording(cx *gin.Context, operation policy.StreamOperation, pod, container string) {
+	context, err := r.deriveContext(cx)
+	if err != nil {
+		return
+	}
+	recording, found := r.acl.Recording(context)
+	if !found || !recording.Records(operation) {
+		return
+	}
+	if len(recording.NamespaceLabels) > 0 {
+		labels, err := r.namespaceLabels(context.Namespace)
+		if err != nil {
+			glog.Warningf("unable to retrieve the namespace: %s, recording the session, error: %s", context.Namespace, err)
+		} else if !recording.Selects(labels) {
+			return
+		}
+	}
+
+	meta := &sessions.Metadata{
+		Operation: string(operation),
+		Namespace: context.Namespace,
+		Pod:       pod,
+		Container: container,
+		Command:   cx.Request.URL.Query()["command"],
+	}
+	if context.User != nil {
+		meta.User = context.User.GetName()
+		meta.Groups = context.User.GetGroups()
+	}
+
+	cx.Set(sessionContextKey, meta)
+}
+
+// namespaceLabels retrieves the labels of the namespace
+func (r *KubeCover) namespaceLabels(name string) (map[string]string, error) {
+	namespace := new(api.Namespace)
+	if err := r.upstreamRequest("GET", fmt.Sprintf("/api/v1/namespaces/%s", name), "", nil, namespace); err != nil {
+		return nil, err
+	}
+
+	return namespace.Labels, nil
+}
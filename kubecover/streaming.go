@@ -0,0 +1,112 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+// streamingWriter writes the headers ahead of flushing the response, as the gin writer defers the headers
+// until the body is written, leaving the watches without a response until the first event
+type streamingWriter struct {
+	gin.ResponseWriter
+}
+
+// Flush writes the headers if required and flushes the response to the client
+func (r streamingWriter) Flush() {
+	r.WriteHeaderNow()
+	r.ResponseWriter.Flush()
+}
+
+// serveStream proxies the streaming response, flushing as it's written; the proxy aborts the handler once
+// either side of the stream has gone, which is expected and not a failure of the service
+func (r *KubeCover) serveStream(cx *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			if err != http.ErrAbortHandler {
+				panic(err)
+			}
+			if cx.Request.Context().Err() != nil {
+				glog.V(10).Infof("the client has disconnected from the stream, uri: %s", cx.Request.URL.Path)
+				return
+			}
+			glog.Warningf("the upstream stream has been interrupted, uri: %s", cx.Request.URL.Path)
+		}
+	}()
+
+	r.streamer.ServeHTTP(streamingWriter{cx.Writer}, cx.Request)
+}
+
+// streamingHandler lifts the write timeout of the server from the streaming and upgraded requests
+func streamingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isStreamingRequest(req) || isUpgradedConnection(req) {
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+				glog.Warningf("unable to lift the write timeout, uri: %s, error: %s", req.URL.Path, err)
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// proxyError handles the errors of the reverse proxy, the client having gone being expected of the streams
+func proxyError(w http.ResponseWriter, req *http.Request, err error) {
+	if req.Context().Err() != nil {
+		glog.V(10).Infof("the client has disconnected, uri: %s", req.URL.Path)
+		return
+	}
+	glog.Errorf("unable to proxy the request, uri: %s, error: %s", req.URL.Path, err)
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		writeStatus(w, http.StatusServiceUnavailable, unversioned.StatusReasonServiceUnavailable, "the upstream is unavailable, the circuit is open")
+	case errors.Is(err, context.DeadlineExceeded):
+		writeStatus(w, http.StatusGatewayTimeout, unversioned.StatusReasonTimeout, "the upstream did not respond in time")
+	default:
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
+
+// isStreamingRequest checks if the response of the request is streamed, i.e. a watch or following the logs
+func isStreamingRequest(req *http.Request) bool {
+	query := req.URL.Query()
+	for _, name := range []string{"watch", "follow"} {
+		if streaming, err := strconv.ParseBool(query.Get(name)); err == nil && streaming {
+			return true
+		}
+	}
+
+	// step: check for the deprecated watch paths, i.e. /api/v1/watch/pods
+	elements := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(elements) > 2 && elements[0] == "api":
+		return elements[2] == "watch"
+	case len(elements) > 3 && elements[0] == "apis":
+		return elements[3] == "watch"
+	}
+
+	return false
+}
@@ -0,0 +1,293 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gambol99/kube-cover/sessions"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+)
+
+const (
+	// the time permitted for the upstream to accept the upgrade
+	upgradeHandshakeTimeout = 30 * time.Second
+	// the time permitted to write the notice of a closed session to the client
+	upgradeNoticeTimeout = 5 * time.Second
+)
+
+// halfCloser is a connection which can close the write side, i.e. tcp, tls and unix connections
+type halfCloser interface {
+	CloseWrite() error
+}
+
+// upgradeSession is the upgraded (websocket or spdy) connection between the client and the upstream
+type upgradeSession struct {
+	// the client connection
+	client net.Conn
+	// the upstream connection
+	upstream net.Conn
+	// the unix time in nanoseconds of the last transfer
+	activity int64
+	// closes the session once
+	closer sync.Once
+	// the demultiplexer of the streams, if the protocol is understood
+	demuxer *sessions.Demuxer
+	// serializes the writes to the client, keeping the frames whole
+	lock sync.Mutex
+}
+
+// tryUpdateConnection proxies the upgrade request to the upstream and, once the upstream has switched
+// protocols, hijacks the client connection and transfers the streams until either side is done
+func (r *KubeCover) tryUpdateConnection(cx *gin.Context) error {
+	limits := &sessionLimits{maxDuration: r.config.UpgradeMaxDuration, idleTimeout: r.config.UpgradeIdleTimeout}
+	if value, found := cx.Get(limitsContextKey); found {
+		limits = value.(*sessionLimits)
+	}
+
+	// step: refuse the session if the user holds the maximum permitted
+	if !r.upgrades.acquire(limits.user, limits.maxSessions) {
+		glog.Warningf("refusing the session, user: %s has the maximum of %d sessions", limits.user, limits.maxSessions)
+		r.statusResponse(cx, http.StatusTooManyRequests, statusReasonTooManyRequests,
+			fmt.Sprintf("the maximum of %d concurrent sessions has been reached", limits.maxSessions))
+		return nil
+	}
+	defer r.upgrades.release(limits.user)
+
+	// step: create the recording of the session if required
+	var recorder *sessions.Recorder
+	if value, found := cx.Get(sessionContextKey); found {
+		var err error
+		if recorder, err = sessions.NewRecorder(r.config.SessionsDir, value.(*sessions.Metadata)); err != nil {
+			return fmt.Errorf("unable to record the session, %s", err)
+		}
+		defer recorder.Close()
+		glog.Infof("recording the session, uri: %s, file: %s", cx.Request.URL.Path, recorder.Name())
+	}
+
+	// step: dial the kubernetes endpoint
+	upstreamConn, endpoint, err := r.dialUpstream()
+	if err != nil {
+		return err
+	}
+	defer endpoint.release()
+	upstreamConn.SetDeadline(time.Now().Add(upgradeHandshakeTimeout))
+
+	// step: write the request to upstream and read the response
+	if r.proxyCredentials {
+		r.credentials.setCredentials(cx.Request)
+	}
+	if err = cx.Request.Write(upstreamConn); err != nil {
+		upstreamConn.Close()
+		return err
+	}
+	upstreamReader := bufio.NewReader(upstreamConn)
+	resp, err := http.ReadResponse(upstreamReader, cx.Request)
+	if err != nil {
+		upstreamConn.Close()
+		return err
+	}
+
+	// step: propagate the response if the upstream has refused the upgrade
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer upstreamConn.Close()
+		defer resp.Body.Close()
+		glog.V(10).Infof("upstream refused the upgrade, uri: %s, status: %d", cx.Request.URL.Path, resp.StatusCode)
+
+		for name, values := range resp.Header {
+			cx.Writer.Header()[name] = values
+		}
+		cx.Writer.WriteHeader(resp.StatusCode)
+		io.Copy(cx.Writer, resp.Body)
+
+		return nil
+	}
+	if !strings.EqualFold(resp.Header.Get(headerUpgrade), cx.Request.Header.Get(headerUpgrade)) {
+		upstreamConn.Close()
+		return fmt.Errorf("upstream switched to protocol: %s, requested: %s", resp.Header.Get(headerUpgrade), cx.Request.Header.Get(headerUpgrade))
+	}
+
+	// step: demultiplex the streams of the session into the recording, and in order to notify the client
+	// on closing the session
+	demuxer, err := sessions.NewDemuxer(recorder, resp.Header.Get(headerUpgrade), resp.Header.Get("Sec-WebSocket-Protocol"))
+	if err != nil && recorder != nil {
+		upstreamConn.Close()
+		return err
+	}
+	var clientTap, upstreamTap func([]byte) error
+	if demuxer != nil {
+		clientTap, upstreamTap = demuxer.ClientData, demuxer.UpstreamData
+	}
+
+	// step: we need to hijack the underlining client connection
+	clientConn, clientBuffer, err := cx.Writer.(http.Hijacker).Hijack()
+	if err != nil {
+		upstreamConn.Close()
+		return fmt.Errorf("unable to hijack the client connection, %s", err)
+	}
+	session := &upgradeSession{client: clientConn, upstream: upstreamConn, demuxer: demuxer, activity: time.Now().UnixNano()}
+	defer session.close()
+
+	// step: pass the switch on to the client
+	clientConn.SetWriteDeadline(time.Now().Add(upgradeHandshakeTimeout))
+	if err := writeSwitchingProtocols(clientConn, resp); err != nil {
+		return nil
+	}
+	upstreamConn.SetDeadline(time.Time{})
+	clientConn.SetDeadline(time.Time{})
+
+	glog.V(10).Infof("upgraded the connection to %s, uri: %s", resp.Header.Get(headerUpgrade), cx.Request.URL.Path)
+
+	// step: copy the data between client and upstream endpoint, the buffered readers holding any data
+	// read ahead of the upgrade
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go session.transfer(upstreamReader, clientConn, upstreamTap, &session.lock, &wg)
+	go session.transfer(clientBuffer.Reader, upstreamConn, clientTap, nil, &wg)
+	done := make(chan struct{})
+	go session.watch(limits.idleTimeout, limits.maxDuration, done)
+	wg.Wait()
+	close(done)
+
+	glog.V(10).Infof("closing the http stream from upstream and client")
+
+	return nil
+}
+
+// writeSwitchingProtocols writes the upgrade response of the upstream to the client
+func writeSwitchingProtocols(conn net.Conn, resp *http.Response) error {
+	writer := bufio.NewWriter(conn)
+	fmt.Fprintf(writer, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(writer)
+	writer.WriteString("\r\n")
+
+	return writer.Flush()
+}
+
+// transfer copies the stream from the source to the destination, passing the data to the tap if any, and half
+// closing the destination once the source is done, or closing the session on an error; the session is terminated
+// should the recording fail, the data not being forwarded
+func (r *upgradeSession) transfer(src io.Reader, dest net.Conn, tap func([]byte) error, guard sync.Locker, wg *sync.WaitGroup) {
+	defer wg.Done()
+	buffer := make([]byte, 32*1024)
+	for {
+		size, err := src.Read(buffer)
+		if size > 0 {
+			atomic.StoreInt64(&r.activity, time.Now().UnixNano())
+			if err := r.forward(buffer[:size], dest, tap, guard); err != nil {
+				if errors.Is(err, sessions.ErrRecordingFailed) {
+					glog.Errorf("terminating the session, error: %s", err)
+					r.terminate("the session can no longer be recorded")
+					return
+				}
+				r.close()
+				return
+			}
+		}
+		if err == io.EOF {
+			if closer, found := dest.(halfCloser); found {
+				if closer.CloseWrite() == nil {
+					return
+				}
+			}
+			r.close()
+			return
+		}
+		if err != nil {
+			r.close()
+			return
+		}
+	}
+}
+
+// forward passes the data to the tap and writes it to the destination, holding the guard if any
+func (r *upgradeSession) forward(data []byte, dest net.Conn, tap func([]byte) error, guard sync.Locker) error {
+	if guard != nil {
+		guard.Lock()
+		defer guard.Unlock()
+	}
+	if tap != nil {
+		if err := tap(data); err != nil {
+			return err
+		}
+	}
+	_, err := dest.Write(data)
+
+	return err
+}
+
+// watch closes the session once it has been idle or open for longer than permitted, zero disabling
+func (r *upgradeSession) watch(idle, maximum time.Duration, done chan struct{}) {
+	if idle <= 0 && maximum <= 0 {
+		return
+	}
+	started := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			last := time.Unix(0, atomic.LoadInt64(&r.activity))
+			if idle > 0 && time.Since(last) > idle {
+				glog.V(4).Infof("closing the upgraded connection, idle for %s", time.Since(last))
+				r.terminate(fmt.Sprintf("the session has been idle for longer than %s", idle))
+				return
+			}
+			if maximum > 0 && time.Since(started) > maximum {
+				glog.V(4).Infof("closing the upgraded connection, exceeded the maximum duration: %s", maximum)
+				r.terminate(fmt.Sprintf("the session has been open for longer than the maximum of %s", maximum))
+				return
+			}
+		}
+	}
+}
+
+// terminate closes the session, first writing the reason to the stderr of the client if the protocol
+// permits, the write deadline releasing a transfer blocked on a client which is not reading
+func (r *upgradeSession) terminate(reason string) {
+	r.client.SetWriteDeadline(time.Now().Add(upgradeNoticeTimeout))
+	r.lock.Lock()
+	if r.demuxer != nil && r.demuxer.Boundary() {
+		if notice := r.demuxer.Notice(fmt.Sprintf("\r\nkube-cover: %s, closing the session\r\n", reason)); notice != nil {
+			r.client.Write(notice)
+		}
+	}
+	r.lock.Unlock()
+	r.close()
+}
+
+// close closes both sides of the session
+func (r *upgradeSession) close() {
+	r.closer.Do(func() {
+		r.client.Close()
+		r.upstream.Close()
+	})
+}
@@ -0,0 +1,178 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+// newUpgradeUpstream creates an upstream which refuses, switches to the wrong protocol or accepts the upgrade,
+// answering the accepted sessions with the data received followed by a goodbye once the client half closes
+func newUpgradeUpstream(t *testing.T) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/refuse":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"kind":"Status","status":"Failure","reason":"Forbidden","code":403}`)
+			return
+		}
+		conn, buffer, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("unable to hijack the upstream connection, error: %s", err)
+			return
+		}
+		defer conn.Close()
+		protocol := req.Header.Get("Upgrade")
+		if req.URL.Path == "/wrong" {
+			protocol = "websocket"
+		}
+		fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", protocol)
+
+		content, _ := ioutil.ReadAll(buffer)
+		conn.Write(content)
+		conn.Write([]byte(" bye"))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newUpgradeProxy creates the service in front of the upstream, upgrading the connections as the proxy handler does
+func newUpgradeProxy(t *testing.T, upstream string) *httptest.Server {
+	tokenFile := filepath.Join(t.TempDir(), "tokens.csv")
+	if err := ioutil.WriteFile(tokenFile, []byte("token,admin,1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewCover(&Config{
+		UpstreamURL:      upstream,
+		UpstreamInsecure: true,
+		TokenAuthFile:    tokenFile,
+		PolicyFile:       "../tests/policies.json",
+	})
+	if err != nil {
+		t.Fatalf("unable to create the service, error: %s", err)
+	}
+	router := gin.New()
+	router.NoRoute(func(cx *gin.Context) {
+		if err := service.tryUpdateConnection(cx); err != nil {
+			service.statusResponse(cx, http.StatusBadGateway, unversioned.StatusReasonServiceUnavailable, "unable to upgrade the connection to the upstream")
+		}
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// upgradeRequest sends an upgrade request on the connection and reads the response
+func upgradeRequest(t *testing.T, conn net.Conn, reader *bufio.Reader, uri string) *http.Response {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: kube-cover\r\nConnection: Upgrade\r\nUpgrade: SPDY/3.1\r\n\r\n", uri)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("uri: %s, unable to read the response, error: %s", uri, err)
+	}
+
+	return resp
+}
+
+func TestTryUpdateConnection(t *testing.T) {
+	upstream := newUpgradeUpstream(t)
+	proxy := newUpgradeProxy(t, upstream.URL)
+
+	cases := []struct {
+		uri    string
+		code   int
+		reason string
+	}{
+		{uri: "/refuse", code: http.StatusForbidden, reason: "Forbidden"},
+		{uri: "/wrong", code: http.StatusBadGateway, reason: "ServiceUnavailable"},
+		{uri: "/exec", code: http.StatusSwitchingProtocols},
+	}
+	for _, c := range cases {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(conn)
+		resp := upgradeRequest(t, conn, reader, c.uri)
+		if resp.StatusCode != c.code {
+			t.Errorf("uri: %s, expected the status: %d, got: %d", c.uri, c.code, resp.StatusCode)
+		}
+
+		if c.code != http.StatusSwitchingProtocols {
+			content, _ := ioutil.ReadAll(resp.Body)
+			if !strings.Contains(string(content), `"reason":"`+c.reason+`"`) {
+				t.Errorf("uri: %s, expected the reason: %s, got: %s", c.uri, c.reason, content)
+			}
+			// step: the refusal is relayed without hijacking, the connection serving another request
+			if again := upgradeRequest(t, conn, reader, c.uri); again.StatusCode != c.code {
+				t.Errorf("uri: %s, expected the connection to be reused, got: %d", c.uri, again.StatusCode)
+			}
+			conn.Close()
+			continue
+		}
+
+		// step: the client half closes, the upstream still answering before closing
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		conn.(*net.TCPConn).CloseWrite()
+		content, err := ioutil.ReadAll(reader)
+		if err != nil && err != io.EOF {
+			t.Errorf("uri: %s, unable to read the session, error: %s", c.uri, err)
+		}
+		if string(content) != "hello bye" {
+			t.Errorf("uri: %s, expected the session to be transferred after the half close, got: %q", c.uri, content)
+		}
+		conn.Close()
+	}
+}
+
+func TestTryUpdateConnectionUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	proxy := newUpgradeProxy(t, "https://"+address)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if resp := upgradeRequest(t, conn, bufio.NewReader(conn), "/exec"); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected the dial failure to be a bad gateway, got: %d", resp.StatusCode)
+	}
+}
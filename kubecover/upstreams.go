@@ -0,0 +1,426 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// BalanceRoundRobin rotates the requests between the healthy upstreams
+	BalanceRoundRobin = "round-robin"
+	// BalanceLeastConnections sends the requests to the healthy upstream with the fewest active
+	BalanceLeastConnections = "least-connections"
+
+	// the time permitted for the health check of an upstream
+	upstreamHealthTimeout = 5 * time.Second
+	// the start of a gateway error peeked for the kind of a Status
+	statusPeekSize = 512
+)
+
+// statusKind matches the kind of a Status returned by the api
+var statusKind = regexp.MustCompile(`"kind"\s*:\s*"Status"`)
+
+// upstreamPool is the upstream endpoints the requests are balanced between
+type upstreamPool struct {
+	// the endpoints of the pool
+	endpoints []*upstreamEndpoint
+	// the balancing strategy
+	balance string
+	// the position of the round robin
+	next uint64
+}
+
+// upstreamEndpoint is an upstream of the pool
+type upstreamEndpoint struct {
+	// the url of the upstream
+	location *url.URL
+	// the number of active requests and connections
+	active int64
+	// set when the upstream is failing the health checks
+	unhealthy int32
+	// the circuit breaker of the upstream
+	breaker circuitBreaker
+}
+
+// upstreamTransport picks an upstream from the pool for each request, retrying the idempotent
+// requests on another upstream when the connection fails
+type upstreamTransport struct {
+	// the pool of upstreams
+	pool *upstreamPool
+	// the transport to the upstreams
+	transport http.RoundTripper
+	// the time to wait for the response headers
+	responseTimeout time.Duration
+	// the total time of a request
+	requestTimeout time.Duration
+	// the total time of a watch or log follow
+	streamTimeout time.Duration
+}
+
+// peekedBody is a response body partly buffered by a peek
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// trackedBody releases the upstream once the response body is closed
+type trackedBody struct {
+	io.ReadCloser
+	// releases the upstream once
+	once sync.Once
+	// the upstream of the response
+	endpoint *upstreamEndpoint
+	// cancels the context of the request
+	cancel context.CancelFunc
+}
+
+// newUpstreamPool creates the pool of upstreams from a comma separated list of urls, each with a circuit
+// breaker opened by the threshold of consecutive failures
+func newUpstreamPool(urls, balance string, threshold int, timeout time.Duration) (*upstreamPool, error) {
+	if balance != BalanceRoundRobin && balance != BalanceLeastConnections {
+		return nil, fmt.Errorf("invalid upstream balance: %s, must be %s or %s", balance, BalanceRoundRobin, BalanceLeastConnections)
+	}
+	pool := &upstreamPool{balance: balance}
+	for _, x := range strings.Split(urls, ",") {
+		if x = strings.TrimSpace(x); x == "" {
+			continue
+		}
+		location, err := url.Parse(x)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream url: %s, %s", x, err)
+		}
+		pool.endpoints = append(pool.endpoints, &upstreamEndpoint{
+			location: location,
+			breaker:  circuitBreaker{upstream: location.String(), threshold: threshold, timeout: timeout},
+		})
+	}
+	if len(pool.endpoints) <= 0 {
+		return nil, fmt.Errorf("no upstream url specified")
+	}
+
+	return pool, nil
+}
+
+// pick selects a healthy upstream not already tried, falling back to the unhealthy upstreams
+// as the health checks may lag behind the recovery of an upstream; the upstreams with an open
+// circuit are skipped
+func (r *upstreamPool) pick(tried map[*upstreamEndpoint]bool) (*upstreamEndpoint, error) {
+	skipped := make(map[*upstreamEndpoint]bool)
+	for {
+		var healthy, unhealthy []*upstreamEndpoint
+		open := false
+		for _, x := range r.endpoints {
+			switch {
+			case tried[x]:
+			case skipped[x] || !x.breaker.available():
+				open = true
+			case x.healthy():
+				healthy = append(healthy, x)
+			default:
+				unhealthy = append(unhealthy, x)
+			}
+		}
+		candidates := healthy
+		if len(candidates) <= 0 {
+			candidates = unhealthy
+		}
+		if len(candidates) <= 0 {
+			if open {
+				return nil, errCircuitOpen
+			}
+			return nil, fmt.Errorf("no upstream available")
+		}
+
+		// step: claim the upstream, another request may have taken the probe of a half open circuit
+		chosen := r.choose(candidates)
+		if chosen.breaker.allow() {
+			return chosen, nil
+		}
+		skipped[chosen] = true
+	}
+}
+
+// choose selects an upstream from the candidates by the balancing strategy
+func (r *upstreamPool) choose(candidates []*upstreamEndpoint) *upstreamEndpoint {
+
+	// step: rotate the starting position, which also breaks the ties of the least connections
+	offset := int(atomic.AddUint64(&r.next, 1) % uint64(len(candidates)))
+	chosen := candidates[offset]
+	if r.balance == BalanceLeastConnections {
+		for i := range candidates {
+			x := candidates[(offset+i)%len(candidates)]
+			if atomic.LoadInt64(&x.active) < atomic.LoadInt64(&chosen.active) {
+				chosen = x
+			}
+		}
+	}
+
+	return chosen
+}
+
+// healthy checks if the upstream is passing the health checks
+func (r *upstreamEndpoint) healthy() bool {
+	return atomic.LoadInt32(&r.unhealthy) == 0
+}
+
+// setHealth records the health of the upstream, logging the changes
+func (r *upstreamEndpoint) setHealth(healthy bool, reason error) {
+	switch {
+	case healthy && atomic.CompareAndSwapInt32(&r.unhealthy, 1, 0):
+		glog.Infof("the upstream: %s is healthy", r.location)
+	case !healthy && atomic.CompareAndSwapInt32(&r.unhealthy, 0, 1):
+		glog.Warningf("the upstream: %s is unhealthy, error: %s", r.location, reason)
+	}
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	upstreamHealthGauge.WithLabelValues(r.location.String()).Set(value)
+}
+
+// acquire counts an active request or connection against the upstream
+func (r *upstreamEndpoint) acquire() {
+	atomic.AddInt64(&r.active, 1)
+}
+
+// release removes an active request or connection from the upstream
+func (r *upstreamEndpoint) release() {
+	atomic.AddInt64(&r.active, -1)
+}
+
+// RoundTrip sends the request to an upstream from the pool
+func (r *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tried := make(map[*upstreamEndpoint]bool)
+	var failed error
+	for {
+		endpoint, err := r.pool.pick(tried)
+		if err != nil {
+			// step: the last failure is more useful than the exhaustion of the upstreams
+			if failed != nil {
+				return nil, failed
+			}
+			return nil, err
+		}
+		tried[endpoint] = true
+
+		resp, err := r.roundTrip(req, endpoint)
+		if err == nil {
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			endpoint.breaker.abandon()
+			return nil, err
+		}
+		if isUpstreamFailure(err) {
+			endpoint.breaker.failure(err)
+		} else {
+			endpoint.breaker.abandon()
+		}
+		if isDialError(err) {
+			endpoint.setHealth(false, err)
+		}
+		failed = err
+
+		// step: retry the idempotent requests if the connection to the upstream failed
+		if !isRetryableRequest(req) || len(tried) >= len(r.pool.endpoints) {
+			return nil, err
+		}
+		glog.Warningf("the request to upstream: %s failed, retrying, uri: %s, error: %s", endpoint.location, req.URL.Path, err)
+	}
+}
+
+// roundTrip sends the request to the upstream, bounded by the response and request timeouts
+func (r *upstreamTransport) roundTrip(req *http.Request, endpoint *upstreamEndpoint) (*http.Response, error) {
+	// step: the watches and log follows are permitted the longer stream timeout
+	total := r.requestTimeout
+	if isStreamingRequest(req) {
+		total = r.streamTimeout
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if total > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), total)
+	} else {
+		ctx, cancel = context.WithCancel(req.Context())
+	}
+
+	outreq := req.Clone(ctx)
+	if endpoint.location.Scheme != "unix" {
+		outreq.URL.Scheme = endpoint.location.Scheme
+		outreq.URL.Host = endpoint.location.Host
+	}
+	var timer *time.Timer
+	if r.responseTimeout > 0 {
+		timer = time.AfterFunc(r.responseTimeout, cancel)
+	}
+
+	endpoint.acquire()
+	resp, err := r.transport.RoundTrip(outreq)
+	if timer != nil && !timer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		err = fmt.Errorf("the upstream: %s did not respond within %s, %w", endpoint.location, r.responseTimeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		cancel()
+		endpoint.release()
+		return nil, err
+	}
+
+	// step: the gateway errors in front of the upstream count against the circuit, the Status responses
+	// of the api itself (i.e. an unavailable aggregated api) being a response of a healthy upstream
+	if isGatewayFailure(resp) {
+		endpoint.breaker.failure(fmt.Errorf("upstream responded with %d", resp.StatusCode))
+	} else {
+		endpoint.breaker.success()
+	}
+	resp.Body = &trackedBody{ReadCloser: resp.Body, endpoint: endpoint, cancel: cancel}
+
+	return resp, nil
+}
+
+// Close releases the upstream and closes the body
+func (r *trackedBody) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(func() {
+		r.cancel()
+		r.endpoint.release()
+	})
+
+	return err
+}
+
+// dialUpstream dials an upstream from the pool, trying the others should the dial fail
+func (r *KubeCover) dialUpstream() (net.Conn, *upstreamEndpoint, error) {
+	tried := make(map[*upstreamEndpoint]bool)
+	for {
+		endpoint, err := r.upstreams.pick(tried)
+		if err != nil {
+			return nil, nil, err
+		}
+		tried[endpoint] = true
+
+		conn, err := tryDialEndpoint(endpoint.location, r.proxyTLS)
+		if err == nil {
+			endpoint.breaker.success()
+			endpoint.acquire()
+			return conn, endpoint, nil
+		}
+		endpoint.breaker.failure(err)
+		if isDialError(err) {
+			endpoint.setHealth(false, err)
+		}
+		if len(tried) >= len(r.upstreams.endpoints) {
+			return nil, nil, err
+		}
+		glog.Warningf("unable to dial the upstream: %s, trying another, error: %s", endpoint.location, err)
+	}
+}
+
+// checkUpstreams checks the /healthz of each upstream on the interval
+func (r *KubeCover) checkUpstreams(interval time.Duration) {
+	client := &http.Client{Transport: r.transport, Timeout: upstreamHealthTimeout}
+	for {
+		for _, x := range r.upstreams.endpoints {
+			err := r.checkUpstream(client, x)
+			x.setHealth(err == nil, err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// checkUpstream checks the upstream responds to /healthz
+func (r *KubeCover) checkUpstream(client *http.Client, endpoint *upstreamEndpoint) error {
+	request, err := http.NewRequest("GET", endpoint.location.ResolveReference(&url.URL{Path: "/healthz"}).String(), nil)
+	if err != nil {
+		return err
+	}
+	r.credentials.setCredentials(request)
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("healthz responded with %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// isRetryableRequest checks if the request is idempotent and without a body, so can be sent again
+func isRetryableRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody
+	}
+
+	return false
+}
+
+// isUpstreamFailure checks if the error counts against the circuit of the upstream, i.e. the connection
+// failed or the upstream timed out
+func isUpstreamFailure(err error) bool {
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return true
+	}
+
+	return isDialError(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isGatewayFailure checks if the response is a bad gateway or gateway timeout which was not returned
+// by the api as a Status, the start of the body being peeked for the kind
+func isGatewayFailure(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusBadGateway && resp.StatusCode != http.StatusGatewayTimeout {
+		return false
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "application/vnd.kubernetes.protobuf") {
+		return false
+	}
+	reader := bufio.NewReaderSize(resp.Body, statusPeekSize)
+	resp.Body = &peekedBody{Reader: reader, Closer: resp.Body}
+	peek, _ := reader.Peek(statusPeekSize)
+
+	return !statusKind.Match(peek)
+}
+
+// isDialError checks if the error is a failure to connect to the upstream
+func isDialError(err error) bool {
+	var opError *net.OpError
+
+	return errors.As(err, &opError) && opError.Op == "dial"
+}
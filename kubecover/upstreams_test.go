@@ -0,0 +1,84 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package kubecover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIsGatewayFailure(t *testing.T) {
+	status := `{"kind":"Status","apiVersion":"v1","metadata":{},"status":"Failure","code":503}`
+	cases := []struct {
+		code        int
+		contentType string
+		body        string
+		failure     bool
+	}{
+		{code: http.StatusOK, body: "{}"},
+		{code: http.StatusServiceUnavailable, body: "service unavailable"},
+		{code: http.StatusServiceUnavailable, contentType: "application/json", body: status},
+		{code: http.StatusBadGateway, body: "<html>bad gateway</html>", failure: true},
+		{code: http.StatusBadGateway, body: "", failure: true},
+		{code: http.StatusGatewayTimeout, contentType: "text/plain", body: "upstream timed out", failure: true},
+		{code: http.StatusBadGateway, contentType: "application/json", body: status},
+		{code: http.StatusGatewayTimeout, contentType: "application/json", body: `{ "kind" : "Status", "code": 504}`},
+		{code: http.StatusGatewayTimeout, contentType: "application/vnd.kubernetes.protobuf", body: "k8s\x00"},
+		{code: http.StatusBadGateway, body: strings.Repeat(" ", statusPeekSize) + `{"kind":"Status"}`, failure: true},
+	}
+	for i, c := range cases {
+		resp := &http.Response{
+			StatusCode: c.code,
+			Header:     http.Header{"Content-Type": []string{c.contentType}},
+			Body:       ioutil.NopCloser(strings.NewReader(c.body)),
+		}
+		if failure := isGatewayFailure(resp); failure != c.failure {
+			t.Errorf("case %d: expected failure: %t, got: %t", i, c.failure, failure)
+		}
+		// step: the peek must leave the body intact
+		content, err := ioutil.ReadAll(resp.Body)
+		if err != nil || string(content) != c.body {
+			t.Errorf("case %d: expected the body: %q, got: %q, error: %v", i, c.body, content, err)
+		}
+	}
+}
+
+func TestIsUpstreamFailure(t *testing.T) {
+	cases := []struct {
+		err     error
+		failure bool
+	}{
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, failure: true},
+		{err: fmt.Errorf("the upstream did not respond, %w", context.DeadlineExceeded), failure: true},
+		{err: &net.DNSError{IsTimeout: true}, failure: true},
+		{err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}},
+		{err: context.Canceled},
+		{err: errors.New("net/http: request body too large")},
+	}
+	for i, c := range cases {
+		if failure := isUpstreamFailure(c.err); failure != c.failure {
+			t.Errorf("case %d: %s, expected failure: %t, got: %t", i, c.err, c.failure, failure)
+		}
+	}
+}
@@ -18,34 +18,122 @@ limitations under the License.
 package kubecover
 
 import (
-	"crypto/rand"
 	"crypto/tls"
-	"io"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/auth/user"
 )
 
 // buildTransport creates and returns the default transport
-func buildTransport() *http.Transport {
+func buildTransport(tlsConfig *tls.Config) *http.Transport {
 	return &http.Transport{
 		//Proxy: http.ProxyFromEnvironment,
 		Dial: (&net.Dialer{
 			Timeout:   10 * time.Second,
 			KeepAlive: 10 * time.Second,
 		}).Dial,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
+		TLSClientConfig: tlsConfig,
+	}
+}
+
+// buildUnixTransport creates a transport dialing the unix socket
+func buildUnixTransport(path string) *http.Transport {
+	return &http.Transport{
+		Dial: func(network, address string) (net.Conn, error) {
+			return net.DialTimeout("unix", path, 10*time.Second)
 		},
 	}
 }
 
+// serveUnix serves the requests on a unix socket, removing any stale socket first
+func serveUnix(server *http.Server, path string) error {
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	// step: restrict the socket to the owner and group, as with the docker socket
+	if err := os.Chmod(path, 0660); err != nil {
+		return err
+	}
+	glog.Infof("listening on the unix socket: %s", path)
+
+	return server.Serve(listener)
+}
+
+// policyDecision converts the result of a policy evaluation into a decision, allowed or denied
+func policyDecision(err error) string {
+	if err != nil {
+		return "denied"
+	}
+
+	return "allowed"
+}
+
+// policyViolation returns the violation of a policy evaluation, if any
+func policyViolation(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+
+	return "none"
+}
+
+// certificateUser extracts the user from a verified client certificate, the common name being
+// the user and the organizations the groups
+func certificateUser(req *http.Request) user.Info {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) <= 0 {
+		return nil
+	}
+	certificate := req.TLS.VerifiedChains[0][0]
+
+	return &user.DefaultInfo{
+		Name:   certificate.Subject.CommonName,
+		Groups: certificate.Subject.Organization,
+	}
+}
+
+// certificateAuthenticator authenticates the callers by the client certificates the listener has verified
+// against the -client-ca
+type certificateAuthenticator struct{}
+
+// AuthenticateRequest returns the user of the verified client certificate, if any
+func (r certificateAuthenticator) AuthenticateRequest(req *http.Request) (user.Info, bool, error) {
+	if info := certificateUser(req); info != nil {
+		return info, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// loadCertificatePool reads in a bundle of ca certificates
+func loadCertificatePool(path string) (*x509.CertPool, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificates found in the file: %s", path)
+	}
+
+	return pool, nil
+}
+
 // printRequest display the request
 func printRequest(req *http.Request) string {
 	content, err := httputil.DumpRequest(req, true)
@@ -66,15 +154,18 @@ func isUpgradedConnection(req *http.Request) bool {
 }
 
 // tryDialEndpoint dials the upstream endpoint via plain
-func tryDialEndpoint(location *url.URL) (net.Conn, error) {
+func tryDialEndpoint(location *url.URL, tlsConfig *tls.Config) (net.Conn, error) {
 	glog.V(10).Infof("attempting to dial: %s", location.String())
 	// get the dial address
 	dialAddr := dialAddress(location)
 
 	switch location.Scheme {
+	case "unix":
+		glog.V(10).Infof("connecting the unix socket: %s", location.Path)
+		return net.DialTimeout("unix", location.Path, 10*time.Second)
 	case "http":
 		glog.V(10).Infof("connecting the http endpoint: %s", dialAddr)
-		conn, err := net.Dial("tcp", dialAddr)
+		conn, err := net.DialTimeout("tcp", dialAddr, 10*time.Second)
 		if err != nil {
 			return nil, err
 		}
@@ -82,10 +173,7 @@ func tryDialEndpoint(location *url.URL) (net.Conn, error) {
 	default:
 		glog.V(10).Infof("connecting to tls endpoint: %s", dialAddr)
 		// step: construct and dial a tls endpoint
-		conn, err := tls.Dial("tcp", dialAddr, &tls.Config{
-			Rand:               rand.Reader,
-			InsecureSkipVerify: true,
-		})
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", dialAddr, tlsConfig)
 
 		if err != nil {
 			return nil, err
@@ -110,13 +198,15 @@ func dialAddress(location *url.URL) string {
 	return location.Host
 }
 
-// transferBytes transfers bytes between the sink and source
-func transferBytes(src io.Reader, dest io.Writer, wg *sync.WaitGroup) (int64, error) {
-	defer wg.Done()
-	copied, err := io.Copy(dest, src)
-	if err != nil {
-		return copied, err
-	}
-
-	return copied, nil
+// writeStatus writes a kubernetes status failure to the response
+func writeStatus(w http.ResponseWriter, code int, reason unversioned.StatusReason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&unversioned.Status{
+		TypeMeta: unversioned.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   unversioned.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     code,
+	})
 }
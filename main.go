@@ -28,14 +28,91 @@ import (
 )
 
 func main() {
+	// step: handle the sessions command
+	if len(os.Args) > 1 && os.Args[1] == "sessions" {
+		if err := sessionsCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "[error] %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := parseConfig(); err != nil {
 		printUsage(err.Error())
 	}
 
 	glog.Infof("initializing kube cover service, version: %s", version)
 
-	// step: create the kube cover service
-	cover, err := kubecover.NewCover(config.upstreamURL, config.policyFile)
+	options := &kubecover.Config{
+		Mode:          config.mode,
+		ClientCA:      config.clientCA,
+		TokenAuthFile: config.tokenAuthFile,
+		BasicAuthFile: config.basicAuthFile,
+		AnonymousAuth: config.anonymousAuth,
+
+		OIDCIssuerURL:     config.oidcIssuerURL,
+		OIDCClientID:      config.oidcClientID,
+		OIDCJWKS:          config.oidcJWKS,
+		OIDCUsernameClaim: config.oidcUsernameClaim,
+		OIDCGroupsClaim:   config.oidcGroupsClaim,
+
+		TokenReview:            config.tokenReview,
+		TokenReviewTTL:         config.tokenReviewTTL,
+		TokenReviewNegativeTTL: config.tokenReviewNegativeTTL,
+
+		PolicyFile:       config.policyFile,
+		ShadowPolicyFile: config.shadowPolicyFile,
+		RoleFile:         config.roleFile,
+		MetricsBind:      config.metricsBind,
+
+		UpstreamURL:        config.upstreamURL,
+		UpstreamCA:         config.upstreamCA,
+		UpstreamClientCert: config.upstreamClientCert,
+		UpstreamClientKey:  config.upstreamClientKey,
+		UpstreamToken:      config.upstreamToken,
+		UpstreamInsecure:   config.upstreamInsecure,
+		Kubeconfig:         config.kubeconfig,
+		KubeContext:        config.kubeContext,
+		Impersonate:        config.impersonate,
+		DiscoveryCacheTTL:  config.discoveryCacheTTL,
+		ServerWriteTimeout: config.serverWriteTimeout,
+		UpgradeIdleTimeout: config.upgradeIdleTimeout,
+		UpgradeMaxDuration: config.upgradeMaxDuration,
+		SessionsDir:        config.sessionsDir,
+
+		UpstreamBalance:        config.upstreamBalance,
+		UpstreamHealthInterval: config.upstreamHealthInterval,
+
+		UpstreamResponseTimeout:  config.upstreamResponseTimeout,
+		UpstreamRequestTimeout:   config.upstreamRequestTimeout,
+		UpstreamStreamTimeout:    config.upstreamStreamTimeout,
+		UpstreamFailureThreshold: config.upstreamFailureThreshold,
+		UpstreamBreakerTimeout:   config.upstreamBreakerTimeout,
+
+		RateLimit:           config.rateLimit,
+		RateLimitBurst:      config.rateLimitBurst,
+		WriteRateLimit:      config.writeRateLimit,
+		WriteRateLimitBurst: config.writeRateLimitBurst,
+		MaxBodySize:         config.maxBodySize,
+
+		DockerSocket:    config.dockerSocket,
+		DockerNamespace: config.dockerNamespace,
+
+		KubeletAllowedPaths: config.kubeletAllowedPaths,
+
+		Reconcile:            config.reconcile,
+		ReconcileAction:      config.reconcileAction,
+		ReconcileGracePeriod: config.reconcileGracePeriod,
+	}
+
+	// step: create the kube cover service, or a service per cluster
+	var cover service
+	var err error
+	if config.clustersFile != "" {
+		cover, err = kubecover.NewClusters(options, config.clustersFile)
+	} else {
+		cover, err = kubecover.NewCover(options)
+	}
 	if err != nil {
 		printUsage(err.Error())
 	}
@@ -18,10 +18,12 @@ limitations under the License.
 package policy
 
 import (
+	"fmt"
 	"sync"
 
 	"github.com/golang/glog"
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/auth/user"
 	"strings"
 )
 
@@ -53,20 +55,128 @@ func NewController(path string) (Controller, error) {
 // Authorized validates the pod and parameters are valid
 func (r *policyEnforcer) Authorized(cx *PolicyContext, pod *api.PodSpec) error {
 	glog.Infof("validating the pod spec, namespace: %s", cx.Namespace)
+	p, found := r.matchPolicy(cx)
+	if !found {
+		return nil
+	}
+
+	// step: check for conflicts
+	return p.Spec.Conflicts(pod)
+}
+
+// AuthorizedStream validates the streaming operation against the pod is permitted
+func (r *policyEnforcer) AuthorizedStream(cx *PolicyContext, operation StreamOperation, pod, container string) error {
+	glog.V(10).Infof("validating the %s operation, namespace: %s, pod: %s, container: %s", operation, cx.Namespace, pod, container)
+	p, found := r.matchPolicy(cx)
+	if !found || p.Spec.Streaming == nil {
+		return nil
+	}
+
+	return p.Spec.Streaming.Conflicts(operation, pod, container)
+}
+
+// AuthorizedLogs validates reading the logs of the pod is permitted, capping the options of the request
+func (r *policyEnforcer) AuthorizedLogs(cx *PolicyContext, pod string, options *api.PodLogOptions) error {
+	glog.V(10).Infof("validating the logs request, namespace: %s, pod: %s, container: %s", cx.Namespace, pod, options.Container)
+	p, found := r.matchPolicy(cx)
+	if !found || p.Spec.Streaming == nil {
+		return nil
+	}
+	if err := p.Spec.Streaming.Conflicts(StreamLogs, pod, options.Container); err != nil {
+		return err
+	}
+	if p.Spec.Streaming.LogOptions == nil {
+		return nil
+	}
+
+	return p.Spec.Streaming.LogOptions.Conflicts(options)
+}
+
+// AuthorizedProxy validates proxying to the node or service port is permitted
+func (r *policyEnforcer) AuthorizedProxy(cx *PolicyContext, target ProxyTarget, name, port string) error {
+	glog.V(10).Infof("validating the proxy to %s: %s, port: %s, namespace: %s", target, name, port, cx.Namespace)
+	p, found := r.matchPolicy(cx)
+	if !found || p.Spec.Proxy == nil {
+		return nil
+	}
+
+	return p.Spec.Proxy.Conflicts(target, name, port)
+}
+
+// Redaction returns the redaction of the policy matching the context, if any
+func (r *policyEnforcer) Redaction(cx *PolicyContext) (*RedactionPolicy, bool) {
+	p, found := r.matchPolicy(cx)
+	if !found || p.Spec.Redaction == nil {
+		return nil, false
+	}
+
+	return p.Spec.Redaction, true
+}
+
+// Recording returns the recording of the policy matching the context, if any
+func (r *policyEnforcer) Recording(cx *PolicyContext) (*RecordingPolicy, bool) {
+	p, found := r.matchPolicy(cx)
+	if !found || p.Spec.Recording == nil {
+		return nil, false
+	}
+
+	return p.Spec.Recording, true
+}
+
+// Sessions returns the session limits of the policy matching the context, if any
+func (r *policyEnforcer) Sessions(cx *PolicyContext) (*SessionsPolicy, bool) {
+	p, found := r.matchPolicy(cx)
+	if !found || p.Spec.Sessions == nil {
+		return nil, false
+	}
+
+	return p.Spec.Sessions, true
+}
+
+// AuthorizedImpersonation validates the user of the context is permitted to impersonate the user
+// and each of the groups, impersonation is denied unless a rule permits it
+func (r *policyEnforcer) AuthorizedImpersonation(cx *PolicyContext, target user.Info) error {
+	if cx.User == nil {
+		return fmt.Errorf("impersonation by an unauthenticated user")
+	}
+	glog.V(10).Infof("validating the impersonation, user: %s, target: %s, groups: %v",
+		cx.User.GetName(), target.GetName(), target.GetGroups())
+
+	if !r.permitsImpersonation(cx.User, func(p *ImpersonationPolicy) bool { return p.permitsUser(target.GetName()) }) {
+		return fmt.Errorf("impersonation of user: %s", target.GetName())
+	}
+	for _, group := range target.GetGroups() {
+		if !r.permitsImpersonation(cx.User, func(p *ImpersonationPolicy) bool { return p.permitsGroup(group) }) {
+			return fmt.Errorf("impersonation of group: %s", group)
+		}
+	}
+
+	return nil
+}
+
+// permitsImpersonation checks if any of the rules applied to the user permits the target
+func (r *policyEnforcer) permitsImpersonation(caller user.Info, permits func(*ImpersonationPolicy) bool) bool {
+	for _, p := range r.policies.Impersonation {
+		if p.Matches(caller) && permits(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPolicy finds the first policy matching the context
+func (r *policyEnforcer) matchPolicy(cx *PolicyContext) (*PodSecurityPolicy, bool) {
 	for i, p := range r.policies.Items {
 		// step: check if the policy matches
 		if match := p.Matches(cx); !match {
 			continue
 		}
 		glog.V(10).Infof("matched the %d policy, namespace: %s in the list", i,
-			strings.Join(p.Namespaces,","))
+			strings.Join(p.Namespaces, ","))
 
-		// step: check for conflicts
-		if err := p.Spec.Conflicts(pod); err != nil {
-			return err
-		}
-		break
+		return p, true
 	}
 
-	return nil
+	return nil, false
 }
@@ -19,10 +19,25 @@ package policy
 
 import (
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/auth/user"
 )
 
 // Controller validate a pod specification against the security policies
 type Controller interface {
 	// validate a pod against the policies
 	Authorized(*PolicyContext, *api.PodSpec) error
+	// validate a streaming operation against a pod and container
+	AuthorizedStream(*PolicyContext, StreamOperation, string, string) error
+	// validate the logs request against a pod, capping the options
+	AuthorizedLogs(*PolicyContext, string, *api.PodLogOptions) error
+	// validate proxying to a node or service and port via the apiserver
+	AuthorizedProxy(*PolicyContext, ProxyTarget, string, string) error
+	// validate the user of the context can impersonate the user
+	AuthorizedImpersonation(*PolicyContext, user.Info) error
+	// retrieve the redaction applied to the responses of the context
+	Redaction(*PolicyContext) (*RedactionPolicy, bool)
+	// retrieve the recording of the sessions of the context
+	Recording(*PolicyContext) (*RecordingPolicy, bool)
+	// retrieve the limits of the sessions of the context
+	Sessions(*PolicyContext) (*SessionsPolicy, bool)
 }
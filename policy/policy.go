@@ -19,16 +19,29 @@ package policy
 
 import (
 	"fmt"
+	"path"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/gambol99/kube-cover/utils"
 
 	"github.com/golang/glog"
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/auth/user"
 )
 
 // Matches checks to see if the context matches the policy filter
 func (r PodSecurityPolicy) Matches(cx *PolicyContext) bool {
+	if !r.matchesNamespace(cx) || !r.matchesCluster(cx) {
+		return false
+	}
+
+	return r.matchesUser(cx)
+}
+
+// matchesNamespace checks the namespace of the context matches the policy
+func (r PodSecurityPolicy) matchesNamespace(cx *PolicyContext) bool {
 	// check for wild cards
 	if found := utils.ContainedIn("*", r.Namespaces); found {
 		return true
@@ -40,6 +53,126 @@ func (r PodSecurityPolicy) Matches(cx *PolicyContext) bool {
 	return false
 }
 
+// matchesCluster checks the cluster of the context matches the policy, a policy without clusters
+// applying to all
+func (r PodSecurityPolicy) matchesCluster(cx *PolicyContext) bool {
+	if len(r.Clusters) <= 0 || utils.ContainedIn("*", r.Clusters) {
+		return true
+	}
+
+	return utils.ContainedIn(cx.Cluster, r.Clusters)
+}
+
+// matchesUser checks the user of the context matches the policy, a policy without users or
+// groups matches everyone
+func (r PodSecurityPolicy) matchesUser(cx *PolicyContext) bool {
+	if len(r.Users) <= 0 && len(r.Groups) <= 0 {
+		return true
+	}
+	if cx.User == nil {
+		return false
+	}
+	if utils.ContainedIn("*", r.Users) || utils.ContainedIn(cx.User.GetName(), r.Users) {
+		return true
+	}
+	for _, group := range cx.User.GetGroups() {
+		if utils.ContainedIn(group, r.Groups) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Records checks if the sessions of the operation are recorded
+func (r RecordingPolicy) Records(operation StreamOperation) bool {
+	switch operation {
+	case StreamExec:
+		return r.Exec
+	case StreamAttach:
+		return r.Attach
+	}
+
+	return false
+}
+
+// Selects checks the labels of the namespace match the recording policy
+func (r RecordingPolicy) Selects(labels map[string]string) bool {
+	for name, value := range r.NamespaceLabels {
+		if found, exists := labels[name]; !exists || found != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Limits returns the maximum duration and idle timeout of the sessions, tightening the defaults given,
+// zero being unlimited
+func (r SessionsPolicy) Limits(maximum, idle time.Duration) (time.Duration, time.Duration) {
+	return shortestDuration(maximum, r.maxDuration), shortestDuration(idle, r.idleTimeout)
+}
+
+// shortestDuration returns the shorter of the durations, zero being unlimited
+func shortestDuration(a, b time.Duration) time.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+
+	return a
+}
+
+// RedactsEnv checks if the value of the environment variable should be hidden
+func (r RedactionPolicy) RedactsEnv(name, value string) bool {
+	for _, x := range r.env {
+		if x.MatchString(name) || x.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RedactsAnnotation checks if the annotation should be removed
+func (r RedactionPolicy) RedactsAnnotation(name string) bool {
+	return utils.ContainedIn(name, r.Annotations)
+}
+
+// Matches checks if the impersonation rule is applied to the user
+func (r ImpersonationPolicy) Matches(caller user.Info) bool {
+	if utils.ContainedIn("*", r.Users) || utils.ContainedIn(caller.GetName(), r.Users) {
+		return true
+	}
+	for _, group := range caller.GetGroups() {
+		if utils.ContainedIn(group, r.Groups) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// permitsUser checks if the rule permits impersonating the user
+func (r ImpersonationPolicy) permitsUser(name string) bool {
+	return matchesPattern(name, r.TargetUsers)
+}
+
+// permitsGroup checks if the rule permits impersonating the group
+func (r ImpersonationPolicy) permitsGroup(name string) bool {
+	return matchesPattern(name, r.TargetGroups)
+}
+
+// matchesPattern checks if the value matches any of the wildcard patterns
+func matchesPattern(value string, patterns []string) bool {
+	for _, x := range patterns {
+		if matched, _ := path.Match(x, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Conflicts checks if the pod spec violates the security specification
 func (r PodSecurityPolicySpec) Conflicts(pod *api.PodSpec) error {
 	// check for host pid
@@ -227,6 +360,104 @@ func (r VolumeSecurityPolicy) Conflicts(volumes []api.Volume) error {
 	return nil
 }
 
+// Conflicts validates the streaming operation against the pod does not violate the policy
+func (r StreamingSecurityPolicy) Conflicts(operation StreamOperation, pod, container string) error {
+	permitted := map[StreamOperation]bool{
+		StreamExec:        r.Exec,
+		StreamAttach:      r.Attach,
+		StreamPortForward: r.PortForward,
+		StreamLogs:        r.Logs,
+	}
+	if !permitted[operation] {
+		return fmt.Errorf("%s operation", operation)
+	}
+
+	// step: check the pod is permitted
+	if !matchesAny(r.pods, pod) {
+		return fmt.Errorf("%s operation on pod: %s", operation, pod)
+	}
+
+	// step: check the container is permitted, port forwarding being to the pod
+	if operation == StreamPortForward || len(r.containers) <= 0 {
+		return nil
+	}
+	if container == "" {
+		return fmt.Errorf("%s operation on pod: %s requires the container", operation, pod)
+	}
+	if !matchesAny(r.containers, container) {
+		return fmt.Errorf("%s operation on container: %s", operation, container)
+	}
+
+	return nil
+}
+
+// Conflicts checks the options of the logs request are permitted, capping the bytes and lines read
+func (r LogsSecurityPolicy) Conflicts(options *api.PodLogOptions) error {
+	if options.Previous && !r.Previous {
+		return fmt.Errorf("reading the logs of the previous container")
+	}
+	if r.LimitBytes > 0 && (options.LimitBytes == nil || *options.LimitBytes > r.LimitBytes) {
+		limit := r.LimitBytes
+		options.LimitBytes = &limit
+	}
+	if r.TailLines > 0 && (options.TailLines == nil || *options.TailLines > r.TailLines) {
+		lines := r.TailLines
+		options.TailLines = &lines
+	}
+
+	return nil
+}
+
+// matchesAny checks the value matches one of the regexes, an empty list matching everything
+func matchesAny(matchers []*regexp.Regexp, value string) bool {
+	if len(matchers) <= 0 {
+		return true
+	}
+	for _, matcher := range matchers {
+		if matcher.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Conflicts checks if proxying to the node, or the service or pod port is permitted
+func (r ProxySecurityPolicy) Conflicts(target ProxyTarget, name, port string) error {
+	switch target {
+	case ProxyNode:
+		if !r.Nodes {
+			return fmt.Errorf("proxying to node: %s", name)
+		}
+		return nil
+	case ProxyPod:
+		if !proxyPermitted(r.Pods, name, port) {
+			return fmt.Errorf("proxying to pod: %s, port: %s", name, port)
+		}
+		return nil
+	}
+	if !proxyPermitted(r.Services, name, port) {
+		return fmt.Errorf("proxying to service: %s, port: %s", name, port)
+	}
+
+	return nil
+}
+
+// proxyPermitted checks if the name and port match one of the name or name:port of the allowlist
+func proxyPermitted(permitted []string, name, port string) bool {
+	for _, x := range permitted {
+		parts := strings.SplitN(x, ":", 2)
+		if matched, _ := path.Match(parts[0], name); !matched {
+			continue
+		}
+		if len(parts) == 1 || parts[1] == "*" || parts[1] == port {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Conflicts validate the runas pod specification does not violate the security policies
 func (r RunAsUserStrategyOptions) Conflicts(runas *api.SecurityContext) error {
 	return nil
@@ -23,6 +23,7 @@ import (
 
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/auth/user"
 )
 
 // RunAsUserStrategy denotes strategy types for generating RunAsUser values for a
@@ -44,12 +45,42 @@ const (
 	RunAsUserStrategyRunAsAny RunAsUserStrategy = "RunAsAny"
 )
 
+// StreamOperation is a streaming operation performed against a pod
+type StreamOperation string
+
+const (
+	// StreamExec is executing a command in a container
+	StreamExec StreamOperation = "exec"
+	// StreamAttach is attaching to a running container
+	StreamAttach StreamOperation = "attach"
+	// StreamPortForward is forwarding ports to the pod
+	StreamPortForward StreamOperation = "portforward"
+	// StreamLogs is reading the logs of a container
+	StreamLogs StreamOperation = "logs"
+)
+
+// ProxyTarget is the kind of resource proxied to by the apiserver
+type ProxyTarget string
+
+const (
+	// ProxyNode is proxying to the kubelet of a node
+	ProxyNode ProxyTarget = "node"
+	// ProxyService is proxying to a service
+	ProxyService ProxyTarget = "service"
+	// ProxyPod is proxying to a pod
+	ProxyPod ProxyTarget = "pod"
+)
+
 // PolicyContext provides contextual information for authorization
 type PolicyContext struct {
 	// Time is the time
 	Time time.Time
 	// Namespace is the namespace
 	Namespace string
+	// Cluster is the name of the cluster, if routing between clusters
+	Cluster string
+	// User is the user performing the request, if known
+	User user.Info
 }
 
 // PodSecurityPolicy governs the ability to make requests that affect the SecurityContext
@@ -58,6 +89,12 @@ type PodSecurityPolicy struct {
 	unversioned.TypeMeta `json:",inline"`
 	// Namespaces is namespaces the policy is applied to
 	Namespaces []string `json:"namespaces" yaml:"namespaces"`
+	// Clusters is the clusters the policy is applied to, all clusters if not set
+	Clusters []string `json:"clusters" yaml:"clusters"`
+	// Users is the users the policy is applied to, all users if neither users or groups are set
+	Users []string `json:"users" yaml:"users"`
+	// Groups is the groups the policy is applied to, all users if neither users or groups are set
+	Groups []string `json:"groups" yaml:"groups"`
 	// Spec defines the policy enforced.
 	Spec *PodSecurityPolicySpec `json:"spec" yaml:"spec"`
 }
@@ -84,6 +121,92 @@ type PodSecurityPolicySpec struct {
 	SELinuxContext SELinuxContextStrategyOptions `json:"seLinuxContext" yaml:"selinuxcontext"`
 	// RunAsUser is the strategy that will dictate the allowable RunAsUser values that may be set.
 	RunAsUser RunAsUserStrategyOptions `json:"runAsUser" yaml:"runasuser"`
+	// Streaming controls the exec, attach, port forwarding and logs of the pods
+	Streaming *StreamingSecurityPolicy `json:"streaming" yaml:"streaming"`
+	// Redaction hides the sensitive values from the responses read by the users
+	Redaction *RedactionPolicy `json:"redaction" yaml:"redaction"`
+	// Proxy controls the access to the nodes, services and pods via the apiserver proxy
+	Proxy *ProxySecurityPolicy `json:"proxy" yaml:"proxy"`
+	// Recording selects the exec and attach sessions which are recorded
+	Recording *RecordingPolicy `json:"recording" yaml:"recording"`
+	// Sessions limits the upgraded sessions, i.e. exec, attach and port forwarding, of the users
+	Sessions *SessionsPolicy `json:"sessions" yaml:"sessions"`
+}
+
+// StreamingSecurityPolicy allows and disallows the streaming operations against the pods
+type StreamingSecurityPolicy struct {
+	// Exec allows or disallows executing commands in the containers
+	Exec bool `json:"exec" yaml:"exec"`
+	// Attach allows or disallows attaching to the containers
+	Attach bool `json:"attach" yaml:"attach"`
+	// PortForward allows or disallows forwarding ports to the pods
+	PortForward bool `json:"portForward" yaml:"portforward"`
+	// Logs allows or disallows reading the logs of the containers
+	Logs bool `json:"logs" yaml:"logs"`
+	// Pods is a series of regexes, limiting the above to the pods matching
+	Pods []string `json:"pods" yaml:"pods"`
+	// Containers is a series of regexes, limiting the above to the containers matching
+	Containers []string `json:"containers" yaml:"containers"`
+	// LogOptions restricts the options of the logs read
+	LogOptions *LogsSecurityPolicy `json:"logOptions" yaml:"logoptions"`
+	// the above converted to regexes
+	pods []*regexp.Regexp
+	// the above converted to regexes
+	containers []*regexp.Regexp
+}
+
+// LogsSecurityPolicy restricts the logs read from the containers
+type LogsSecurityPolicy struct {
+	// LimitBytes caps the bytes of the logs read, zero being unlimited
+	LimitBytes int64 `json:"limitBytes" yaml:"limitbytes"`
+	// TailLines caps the lines of the logs read, zero being unlimited
+	TailLines int64 `json:"tailLines" yaml:"taillines"`
+	// Previous allows or disallows reading the logs of the previous container
+	Previous bool `json:"previous" yaml:"previous"`
+}
+
+// RecordingPolicy selects the exec and attach sessions which are recorded
+type RecordingPolicy struct {
+	// Exec records the exec sessions
+	Exec bool `json:"exec" yaml:"exec"`
+	// Attach records the attach sessions
+	Attach bool `json:"attach" yaml:"attach"`
+	// NamespaceLabels limits the recording to the namespaces with all the labels, i.e. compliance: pci
+	NamespaceLabels map[string]string `json:"namespaceLabels" yaml:"namespacelabels"`
+}
+
+// SessionsPolicy limits the upgraded sessions of each user
+type SessionsPolicy struct {
+	// MaxSessions caps the concurrent sessions of a user, zero being unlimited
+	MaxSessions int `json:"maxSessions" yaml:"maxsessions"`
+	// MaxDuration is the longest a session can be open, i.e. 8h
+	MaxDuration string `json:"maxDuration" yaml:"maxduration"`
+	// IdleTimeout is the longest a session can be idle, i.e. 30m
+	IdleTimeout string `json:"idleTimeout" yaml:"idletimeout"`
+	// the above converted to durations
+	maxDuration, idleTimeout time.Duration
+}
+
+// ProxySecurityPolicy allows and disallows proxying to the nodes and services via the apiserver
+type ProxySecurityPolicy struct {
+	// Nodes allows or disallows proxying to the nodes
+	Nodes bool `json:"nodes" yaml:"nodes"`
+	// Services is a list of the services permitted, i.e. name or name:port, supports wildcards
+	Services []string `json:"services" yaml:"services"`
+	// Pods is a list of the pods permitted, i.e. name or name:port, supports wildcards
+	Pods []string `json:"pods" yaml:"pods"`
+}
+
+// RedactionPolicy defines the values removed from the objects returned to the users
+type RedactionPolicy struct {
+	// Secrets blanks the data of the secrets
+	Secrets bool `json:"secrets" yaml:"secrets"`
+	// Env is a series of regexes, the container environment values whose name or value matches are replaced
+	Env []string `json:"env" yaml:"env"`
+	// Annotations is a list of annotations removed from the objects
+	Annotations []string `json:"annotations" yaml:"annotations"`
+	// the above converted to regexes
+	env []*regexp.Regexp
 }
 
 // HostPortRange defines a range of host ports that will be enabled by a policy
@@ -183,4 +306,19 @@ type PodSecurityPolicyList struct {
 	unversioned.ListMeta `json:"metadata"`
 
 	Items []*PodSecurityPolicy `json:"items" yaml:"items"`
+	// Impersonation is the rules permitting users to impersonate others
+	Impersonation []*ImpersonationPolicy `json:"impersonation" yaml:"impersonation"`
+}
+
+// ImpersonationPolicy permits the users and groups to impersonate the target users and groups,
+// the targets support wildcards, i.e. system:serviceaccount:ci:*
+type ImpersonationPolicy struct {
+	// Users is the users the rule is applied to
+	Users []string `json:"users" yaml:"users"`
+	// Groups is the groups the rule is applied to
+	Groups []string `json:"groups" yaml:"groups"`
+	// TargetUsers is the users which can be impersonated
+	TargetUsers []string `json:"targetUsers" yaml:"targetusers"`
+	// TargetGroups is the groups which can be impersonated
+	TargetGroups []string `json:"targetGroups" yaml:"targetgroups"`
 }
@@ -19,7 +19,10 @@ package policy
 
 import (
 	"fmt"
+	"path"
 	"regexp"
+	"strings"
+	"time"
 )
 
 func policyValid(policy *PodSecurityPolicyList) error {
@@ -33,6 +36,12 @@ func policyValid(policy *PodSecurityPolicyList) error {
 		}
 	}
 
+	for i, x := range policy.Impersonation {
+		if err := x.isValid(); err != nil {
+			return fmt.Errorf("impersonation rule %d invalid, error: %s", i, err)
+		}
+	}
+
 	return nil
 }
 
@@ -50,6 +59,24 @@ func (r *PodSecurityPolicy) isValid() error {
 	return nil
 }
 
+func (r *ImpersonationPolicy) isValid() error {
+	if len(r.Users) <= 0 && len(r.Groups) <= 0 {
+		return fmt.Errorf("the rule does not have any users or groups")
+	}
+	if len(r.TargetUsers) <= 0 && len(r.TargetGroups) <= 0 {
+		return fmt.Errorf("the rule does not have any target users or groups")
+	}
+	for _, list := range [][]string{r.TargetUsers, r.TargetGroups} {
+		for _, x := range list {
+			if _, err := path.Match(x, ""); err != nil {
+				return fmt.Errorf("pattern: %s is invalid", x)
+			}
+		}
+	}
+
+	return nil
+}
+
 func (r *PodSecurityPolicySpec) isValid() error {
 	if r.Images != nil {
 		if err := r.Images.isValid(); err != nil {
@@ -57,6 +84,30 @@ func (r *PodSecurityPolicySpec) isValid() error {
 		}
 	}
 
+	if r.Streaming != nil {
+		if err := r.Streaming.isValid(); err != nil {
+			return err
+		}
+	}
+
+	if r.Proxy != nil {
+		if err := r.Proxy.isValid(); err != nil {
+			return err
+		}
+	}
+
+	if r.Redaction != nil {
+		if err := r.Redaction.isValid(); err != nil {
+			return err
+		}
+	}
+
+	if r.Sessions != nil {
+		if err := r.Sessions.isValid(); err != nil {
+			return err
+		}
+	}
+
 	for _, x := range r.HostPorts {
 		if err := x.isValid(); err != nil {
 			return err
@@ -88,6 +139,76 @@ func (r *ImageSecurityPolicy) isValid() error {
 	return nil
 }
 
+func (r *StreamingSecurityPolicy) isValid() error {
+	for _, x := range r.Pods {
+		reg, err := regexp.Compile(x)
+		if err != nil {
+			return fmt.Errorf("regex: %s is invalid", x)
+		}
+		r.pods = append(r.pods, reg)
+	}
+	for _, x := range r.Containers {
+		reg, err := regexp.Compile(x)
+		if err != nil {
+			return fmt.Errorf("regex: %s is invalid", x)
+		}
+		r.containers = append(r.containers, reg)
+	}
+	if r.LogOptions != nil && (r.LogOptions.LimitBytes < 0 || r.LogOptions.TailLines < 0) {
+		return fmt.Errorf("the log limits must be positive")
+	}
+
+	return nil
+}
+
+func (r *ProxySecurityPolicy) isValid() error {
+	for _, x := range r.Services {
+		if _, err := path.Match(strings.SplitN(x, ":", 2)[0], ""); err != nil {
+			return fmt.Errorf("service: %s is invalid", x)
+		}
+	}
+	for _, x := range r.Pods {
+		if _, err := path.Match(strings.SplitN(x, ":", 2)[0], ""); err != nil {
+			return fmt.Errorf("pod: %s is invalid", x)
+		}
+	}
+
+	return nil
+}
+
+func (r *RedactionPolicy) isValid() error {
+	for _, x := range r.Env {
+		reg, err := regexp.Compile(x)
+		if err != nil {
+			return fmt.Errorf("regex: %s is invalid", x)
+		}
+		r.env = append(r.env, reg)
+	}
+
+	return nil
+}
+
+func (r *SessionsPolicy) isValid() error {
+	if r.MaxSessions < 0 {
+		return fmt.Errorf("the maximum sessions must be positive")
+	}
+	for _, x := range []struct {
+		value    string
+		duration *time.Duration
+	}{{r.MaxDuration, &r.maxDuration}, {r.IdleTimeout, &r.idleTimeout}} {
+		if x.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(x.value)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("duration: %s is invalid", x.value)
+		}
+		*x.duration = duration
+	}
+
+	return nil
+}
+
 func (r *HostPortRange) isValid() error {
 	if r.Start > r.End {
 		return fmt.Errorf("the start port cannout be greater than end")
@@ -100,4 +221,4 @@ func (r *HostPortRange) isValid() error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
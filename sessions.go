@@ -0,0 +1,72 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gambol99/kube-cover/sessions"
+)
+
+// sessionsCommand lists and plays back the recorded sessions, i.e. kube-cover sessions list|play <name>
+func sessionsCommand(args []string) error {
+	flags := flag.NewFlagSet("sessions", flag.ExitOnError)
+	dir := flags.String("sessions-dir", "/var/lib/kube-cover/sessions", "the directory of the session recordings")
+	speed := flags.Float64("speed", 1, "the playback speed multiplier")
+	idle := flags.Duration("idle-limit", 2*time.Second, "the maximum pause during playback, zero being as recorded")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: kube-cover sessions [options] list|play <recording>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	switch flags.Arg(0) {
+	case "list":
+		list, err := sessions.List(*dir)
+		if err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(writer, "RECORDING\tSTARTED\tDURATION\tUSER\tOPERATION\tNAMESPACE\tPOD\tCONTAINER\tCOMMAND")
+		for _, x := range list {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", x.Name, x.Started.Format(time.RFC3339),
+				x.Duration.Round(time.Second), x.Session.User, x.Session.Operation, x.Session.Namespace,
+				x.Session.Pod, x.Session.Container, strings.Join(x.Session.Command, " "))
+		}
+		return writer.Flush()
+	case "play":
+		if flags.Arg(1) == "" {
+			flags.Usage()
+			return fmt.Errorf("you have not specified the recording to play")
+		}
+		path := flags.Arg(1)
+		if !strings.Contains(path, string(filepath.Separator)) {
+			path = filepath.Join(*dir, path)
+		}
+		return sessions.Play(path, os.Stdout, *speed, *idle)
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown sessions command: %q", flags.Arg(0))
+	}
+}
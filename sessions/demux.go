@@ -0,0 +1,412 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package sessions
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the largest frame buffered by the parsers, the streams using far smaller frames
+	maxFrameSize = 1 << 20
+	// the spdy control frames carrying a header block
+	spdySynStream = 1
+	spdySynReply  = 2
+	spdyHeaders   = 8
+)
+
+// ErrRecordingFailed is returned by the demuxer once the session can no longer be recorded
+var ErrRecordingFailed = errors.New("the session can no longer be recorded")
+
+// websocketChannels are the streams of the kubernetes websocket channel protocols
+var websocketChannels = map[byte]string{0: StreamStdin, 1: StreamStdout, 2: StreamStderr, 4: StreamResize}
+
+// frameParser extracts the frames from the bytes of one direction of the connection
+type frameParser interface {
+	// parse consumes the frame at the start of the buffer, returning the size consumed, or zero if incomplete
+	parse([]byte) (int, error)
+}
+
+// Demuxer demultiplexes the streams of an upgraded exec or attach connection into the recorder, if any
+type Demuxer struct {
+	// the recording of the session
+	recorder *Recorder
+	// the parsers of the client and upstream directions
+	client, upstream *directionParser
+	// the spdy streams, nil for websocket
+	streams *spdyStreams
+	// indicates the websocket channels are base64 encoded
+	encoded bool
+}
+
+// directionParser buffers the bytes of one direction until a frame is complete
+type directionParser struct {
+	// the buffered bytes
+	buffer []byte
+	// the parser of the frames
+	parser frameParser
+	// the error the parser failed with
+	err error
+}
+
+// NewDemuxer creates a demuxer for the upgraded protocol, i.e. SPDY/3.1 or websocket with the kubernetes
+// channel subprotocols
+func NewDemuxer(recorder *Recorder, protocol, subprotocol string) (*Demuxer, error) {
+	demuxer := &Demuxer{recorder: recorder}
+
+	switch {
+	case strings.EqualFold(protocol, "SPDY/3.1"):
+		demuxer.streams = &spdyStreams{types: make(map[uint32]string)}
+		demuxer.client = &directionParser{parser: &spdyParser{streams: demuxer.streams, record: demuxer.record}}
+		demuxer.upstream = &directionParser{parser: &spdyParser{streams: demuxer.streams, record: demuxer.record}}
+	case strings.EqualFold(protocol, "websocket"):
+		demuxer.encoded = strings.Contains(subprotocol, "base64.channel.k8s.io")
+		demuxer.client = &directionParser{parser: &websocketParser{base64: demuxer.encoded, record: demuxer.record}}
+		demuxer.upstream = &directionParser{parser: &websocketParser{base64: demuxer.encoded, record: demuxer.record}}
+	default:
+		return nil, fmt.Errorf("unsupported upgrade protocol: %s", protocol)
+	}
+
+	return demuxer, nil
+}
+
+// ClientData demultiplexes the data sent by the client, returning ErrRecordingFailed should the session
+// be recorded and the data could not be
+func (r *Demuxer) ClientData(data []byte) error {
+	return r.recordingError(r.client.write(data))
+}
+
+// UpstreamData demultiplexes the data sent by the upstream, returning ErrRecordingFailed should the session
+// be recorded and the data could not be
+func (r *Demuxer) UpstreamData(data []byte) error {
+	return r.recordingError(r.upstream.write(data))
+}
+
+// Boundary indicates the upstream direction is between frames, i.e. a frame can be written to the client
+func (r *Demuxer) Boundary() bool {
+	return r.upstream.err == nil && len(r.upstream.buffer) <= 0
+}
+
+// recordingError converts a failure of the demultiplexing into a failure of the recording, if any
+func (r *Demuxer) recordingError(err error) error {
+	if err == nil || r.recorder == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w, %s", ErrRecordingFailed, err)
+}
+
+// Notice encodes the message as a frame of the stderr stream for the client, followed by a close frame for
+// websocket, or nil if the session has no stream to carry it, i.e. a port forward
+func (r *Demuxer) Notice(message string) []byte {
+	if r.streams != nil {
+		return r.streams.notice(message)
+	}
+
+	// step: the server frames of a websocket are unmasked
+	payload := append([]byte{2}, message...)
+	opcode := byte(0x2)
+	if r.encoded {
+		payload = []byte("2" + base64.StdEncoding.EncodeToString([]byte(message)))
+		opcode = 0x1
+	}
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		return nil
+	}
+
+	// step: close the websocket with a normal closure
+	return append(append(frame, payload...), 0x88, 0x02, 0x03, 0xe8)
+}
+
+// record passes the data of a stream to the recorder, if any
+func (r *Demuxer) record(stream string, data []byte) error {
+	if r.recorder == nil {
+		return nil
+	}
+
+	return r.recorder.Record(stream, data)
+}
+
+// write buffers the data and parses any complete frames, the parsing being abandoned on an error, which
+// is returned from then on
+func (r *directionParser) write(data []byte) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.buffer = append(r.buffer, data...)
+	for len(r.buffer) > 0 {
+		size, err := r.parser.parse(r.buffer)
+		if err != nil {
+			glog.Warningf("unable to parse the session stream, demultiplexing stopped, error: %s", err)
+			r.err = err
+			r.buffer = nil
+			return err
+		}
+		if size <= 0 {
+			break
+		}
+		r.buffer = r.buffer[size:]
+	}
+	// step: release the consumed buffer
+	if len(r.buffer) <= 0 {
+		r.buffer = nil
+	}
+
+	return nil
+}
+
+// spdyStreams is the type of the spdy streams, the streams being created by the client
+type spdyStreams struct {
+	sync.RWMutex
+	types map[uint32]string
+}
+
+// notice encodes the message as a data frame of the stderr stream, falling back to the stdout stream of a tty
+func (r *spdyStreams) notice(message string) []byte {
+	r.RLock()
+	defer r.RUnlock()
+	for _, stream := range []string{StreamStderr, StreamStdout} {
+		for id, kind := range r.types {
+			if kind != stream {
+				continue
+			}
+			frame := make([]byte, 8, 8+len(message))
+			binary.BigEndian.PutUint32(frame[0:4], id&0x7fffffff)
+			binary.BigEndian.PutUint32(frame[4:8], uint32(len(message))&0xffffff)
+
+			return append(frame, message...)
+		}
+	}
+
+	return nil
+}
+
+// spdyParser parses the spdy frames of a direction
+type spdyParser struct {
+	// the stream types shared by the directions
+	streams *spdyStreams
+	// the compressed header blocks
+	headers bytes.Buffer
+	// the header decompressor of the direction
+	decompressor io.ReadCloser
+	// the recorder of the streams
+	record func(string, []byte) error
+}
+
+func (r *spdyParser) parse(buffer []byte) (int, error) {
+	if len(buffer) < 8 {
+		return 0, nil
+	}
+	length := int(binary.BigEndian.Uint32(buffer[4:8]) & 0xffffff)
+	if length > maxFrameSize {
+		return 0, fmt.Errorf("spdy frame of %d bytes exceeds the maximum", length)
+	}
+	if len(buffer) < 8+length {
+		return 0, nil
+	}
+	payload := buffer[8 : 8+length]
+
+	// step: handle the data frames
+	if buffer[0]&0x80 == 0 {
+		id := binary.BigEndian.Uint32(buffer[0:4]) & 0x7fffffff
+		r.streams.RLock()
+		stream := r.streams.types[id]
+		r.streams.RUnlock()
+		if stream != "" && len(payload) > 0 {
+			if err := r.record(stream, payload); err != nil {
+				return 0, err
+			}
+		}
+		return 8 + length, nil
+	}
+
+	// step: the header blocks must be decompressed in order to keep the compression context
+	var offset int
+	switch binary.BigEndian.Uint16(buffer[2:4]) {
+	case spdySynStream:
+		offset = 10
+	case spdySynReply, spdyHeaders:
+		offset = 4
+	default:
+		return 8 + length, nil
+	}
+	if len(payload) < offset {
+		return 0, fmt.Errorf("invalid spdy control frame")
+	}
+	headers, err := r.decompress(payload[offset:])
+	if err != nil {
+		return 0, err
+	}
+	if binary.BigEndian.Uint16(buffer[2:4]) == spdySynStream {
+		id := binary.BigEndian.Uint32(payload[0:4]) & 0x7fffffff
+		r.streams.Lock()
+		r.streams.types[id] = headers["streamtype"]
+		r.streams.Unlock()
+	}
+
+	return 8 + length, nil
+}
+
+// decompress decodes the header block of a control frame
+func (r *spdyParser) decompress(block []byte) (map[string]string, error) {
+	r.headers.Write(block)
+	if r.decompressor == nil {
+		decompressor, err := zlib.NewReaderDict(&r.headers, spdyHeaderDictionary)
+		if err != nil {
+			return nil, err
+		}
+		r.decompressor = decompressor
+	}
+
+	var count uint32
+	if err := binary.Read(r.decompressor, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string)
+	for i := uint32(0); i < count; i++ {
+		var fields [2]string
+		for j := range fields {
+			var size uint32
+			if err := binary.Read(r.decompressor, binary.BigEndian, &size); err != nil {
+				return nil, err
+			}
+			if size > maxFrameSize {
+				return nil, fmt.Errorf("spdy header of %d bytes exceeds the maximum", size)
+			}
+			value := make([]byte, size)
+			if _, err := io.ReadFull(r.decompressor, value); err != nil {
+				return nil, err
+			}
+			fields[j] = string(value)
+		}
+		headers[strings.ToLower(fields[0])] = fields[1]
+	}
+
+	return headers, nil
+}
+
+// websocketParser parses the websocket frames of a direction
+type websocketParser struct {
+	// indicates the channels are base64 encoded
+	base64 bool
+	// the fragments of the current message
+	message []byte
+	// the recorder of the streams
+	record func(string, []byte) error
+}
+
+func (r *websocketParser) parse(buffer []byte) (int, error) {
+	if len(buffer) < 2 {
+		return 0, nil
+	}
+	final := buffer[0]&0x80 != 0
+	opcode := buffer[0] & 0x0f
+	masked := buffer[1]&0x80 != 0
+	length := uint64(buffer[1] & 0x7f)
+	offset := 2
+	switch length {
+	case 126:
+		if len(buffer) < 4 {
+			return 0, nil
+		}
+		length = uint64(binary.BigEndian.Uint16(buffer[2:4]))
+		offset = 4
+	case 127:
+		if len(buffer) < 10 {
+			return 0, nil
+		}
+		length = binary.BigEndian.Uint64(buffer[2:10])
+		offset = 10
+	}
+	if length > maxFrameSize {
+		return 0, fmt.Errorf("websocket frame of %d bytes exceeds the maximum", length)
+	}
+	var mask []byte
+	if masked {
+		if len(buffer) < offset+4 {
+			return 0, nil
+		}
+		mask = buffer[offset : offset+4]
+		offset += 4
+	}
+	size := offset + int(length)
+	if len(buffer) < size {
+		return 0, nil
+	}
+
+	// step: the control frames are interleaved with the fragments of the messages
+	if opcode >= 0x8 {
+		return size, nil
+	}
+	payload := make([]byte, length)
+	copy(payload, buffer[offset:size])
+	for i := range payload {
+		if masked {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	r.message = append(r.message, payload...)
+	if len(r.message) > maxFrameSize {
+		return 0, fmt.Errorf("websocket message of %d bytes exceeds the maximum", len(r.message))
+	}
+	if final {
+		if err := r.channel(r.message); err != nil {
+			return 0, err
+		}
+		r.message = nil
+	}
+
+	return size, nil
+}
+
+// channel records the message of a channel, the first byte of which is the channel
+func (r *websocketParser) channel(message []byte) error {
+	if len(message) < 1 {
+		return nil
+	}
+	channel, data := message[0], message[1:]
+	if r.base64 {
+		channel -= '0'
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return fmt.Errorf("invalid base64 on channel %d, %s", channel, err)
+		}
+		data = decoded
+	}
+	if stream, found := websocketChannels[channel]; found && len(data) > 0 {
+		return r.record(stream, data)
+	}
+
+	return nil
+}
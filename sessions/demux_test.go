@@ -0,0 +1,67 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package sessions
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDemuxerRecordingFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	recorder, err := NewRecorder(dir, &Metadata{Namespace: "default", Pod: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+
+	// step: a masked binary frame on the stdin channel, then a frame exceeding the maximum size
+	stdin := []byte{0x82, 0x82, 0, 0, 0, 0, 0, 'a'}
+	oversized := []byte{0x82, 0xff, 0, 0, 0, 0, 0, 0x20, 0, 0}
+	cases := []struct {
+		name     string
+		recorder *Recorder
+		fails    bool
+	}{
+		{name: "recorded", recorder: recorder, fails: true},
+		{name: "not recorded", recorder: nil, fails: false},
+	}
+	for _, c := range cases {
+		demuxer, err := NewDemuxer(c.recorder, "websocket", "channel.k8s.io")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := demuxer.ClientData(stdin); err != nil {
+			t.Errorf("case: %s, unexpected error: %s", c.name, err)
+		}
+		err = demuxer.ClientData(oversized)
+		if c.fails != errors.Is(err, ErrRecordingFailed) {
+			t.Errorf("case: %s, expected failure: %t, error: %v", c.name, c.fails, err)
+		}
+		// step: the failure persists for the remainder of the session
+		if err := demuxer.ClientData(stdin); c.fails != (err != nil) {
+			t.Errorf("case: %s, expected the failure to persist, error: %v", c.name, err)
+		}
+	}
+}
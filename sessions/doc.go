@@ -0,0 +1,47 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package sessions
+
+const (
+	// StreamStdin is the input typed by the user
+	StreamStdin = "stdin"
+	// StreamStdout is the output of the container
+	StreamStdout = "stdout"
+	// StreamStderr is the error output of the container
+	StreamStderr = "stderr"
+	// StreamResize is the terminal size changes
+	StreamResize = "resize"
+)
+
+// Metadata is the details of a recorded session
+type Metadata struct {
+	// User is the user of the session
+	User string `json:"user"`
+	// Groups is the groups of the user
+	Groups []string `json:"groups,omitempty"`
+	// Operation is the streaming operation, i.e. exec or attach
+	Operation string `json:"operation"`
+	// Namespace is the namespace of the pod
+	Namespace string `json:"namespace"`
+	// Pod is the name of the pod
+	Pod string `json:"pod"`
+	// Container is the name of the container
+	Container string `json:"container,omitempty"`
+	// Command is the command executed
+	Command []string `json:"command,omitempty"`
+}
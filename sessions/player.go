@@ -0,0 +1,162 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package sessions
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary describes a recording
+type Summary struct {
+	// Name is the file name of the recording
+	Name string
+	// Started is the time the session started
+	Started time.Time
+	// Duration is the time of the last event
+	Duration time.Duration
+	// Session is the metadata of the session
+	Session *Metadata
+}
+
+// List returns the summary of the recordings in the directory, oldest first
+func List(dir string) ([]*Summary, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var list []*Summary
+	for _, x := range files {
+		if x.IsDir() || filepath.Ext(x.Name()) != recordingExtension {
+			continue
+		}
+		summary, err := summarize(filepath.Join(dir, x.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the recording: %s, error: %s", x.Name(), err)
+		}
+		list = append(list, summary)
+	}
+	sort.Sort(byStarted(list))
+
+	return list, nil
+}
+
+// summarize reads the header and duration of the recording
+func summarize(path string) (*Summary, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	summary := &Summary{Name: filepath.Base(path)}
+	err = readRecording(file, func(h *header) {
+		summary.Started = time.Unix(h.Timestamp, 0)
+		summary.Session = h.Session
+	}, func(elapsed float64, code, data string) error {
+		summary.Duration = time.Duration(elapsed * float64(time.Second))
+		return nil
+	})
+	if summary.Session == nil {
+		summary.Session = &Metadata{}
+	}
+
+	return summary, err
+}
+
+// Play writes the output of the recording to the writer as it happened, the pauses being capped
+// at the idle limit and the speed a multiplier
+func Play(path string, writer io.Writer, speed float64, idle time.Duration) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if speed <= 0 {
+		speed = 1
+	}
+
+	var last float64
+	return readRecording(file, func(*header) {}, func(elapsed float64, code, data string) error {
+		if code != "o" {
+			return nil
+		}
+		pause := time.Duration((elapsed - last) / speed * float64(time.Second))
+		if idle > 0 && pause > idle {
+			pause = idle
+		}
+		last = elapsed
+		time.Sleep(pause)
+		_, err := io.WriteString(writer, data)
+		return err
+	})
+}
+
+// readRecording reads the header and events of the recording
+func readRecording(reader io.Reader, onHeader func(*header), onEvent func(float64, string, string) error) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxFrameSize*2)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("the recording is empty")
+	}
+	h := new(header)
+	if err := json.Unmarshal(scanner.Bytes(), h); err != nil {
+		return fmt.Errorf("invalid header, %s", err)
+	}
+	if h.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version: %d", h.Version)
+	}
+	onHeader(h)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var event []interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("invalid event: %s", line)
+		}
+		elapsed, _ := event[0].(float64)
+		code, _ := event[1].(string)
+		data, _ := event[2].(string)
+		if err := onEvent(elapsed, code, data); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// byStarted sorts the summaries by the start time
+type byStarted []*Summary
+
+func (r byStarted) Len() int           { return len(r) }
+func (r byStarted) Less(i, j int) bool { return r[i].Started.Before(r[j].Started) }
+func (r byStarted) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
@@ -0,0 +1,156 @@
+/*
+
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+*/
+
+package sessions
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// the file extension of the recordings
+	recordingExtension = ".cast"
+	// the terminal size assumed until the client resizes
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// header is the asciicast v2 header of a recording
+type header struct {
+	Version   int       `json:"version"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Timestamp int64     `json:"timestamp"`
+	Command   string    `json:"command,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Session   *Metadata `json:"kubecover,omitempty"`
+}
+
+// Recorder writes the streams of a session to an asciicast v2 file
+type Recorder struct {
+	// the lock guarding the writer
+	lock sync.Mutex
+	// the recording file
+	file *os.File
+	// the buffered writer of the file
+	writer *bufio.Writer
+	// the time the session started
+	started time.Time
+}
+
+// NewRecorder creates a recording of the session in the directory
+func NewRecorder(dir string, meta *Metadata) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	filename := fmt.Sprintf("%s_%s_%s_%s%s", started.UTC().Format("20060102T150405Z"),
+		meta.Namespace, meta.Pod, hex.EncodeToString(suffix), recordingExtension)
+
+	file, err := os.OpenFile(filepath.Join(dir, filename), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	recorder := &Recorder{file: file, writer: bufio.NewWriter(file), started: started}
+
+	// step: write the header of the recording
+	content, err := json.Marshal(&header{
+		Version:   2,
+		Width:     defaultWidth,
+		Height:    defaultHeight,
+		Timestamp: started.Unix(),
+		Command:   strings.Join(meta.Command, " "),
+		Title:     strings.TrimSpace(fmt.Sprintf("%s %s/%s %s", meta.User, meta.Namespace, meta.Pod, meta.Container)),
+		Session:   meta,
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := recorder.write(content); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+// Name returns the path of the recording
+func (r *Recorder) Name() string {
+	return r.file.Name()
+}
+
+// Record adds the data of the stream to the recording
+func (r *Recorder) Record(stream string, data []byte) error {
+	var code, value string
+	switch stream {
+	case StreamStdin:
+		code, value = "i", string(data)
+	case StreamStdout, StreamStderr:
+		code, value = "o", string(data)
+	case StreamResize:
+		size := struct{ Width, Height int }{}
+		if err := json.Unmarshal(data, &size); err != nil {
+			return err
+		}
+		code, value = "r", fmt.Sprintf("%dx%d", size.Width, size.Height)
+	default:
+		return nil
+	}
+	if len(value) <= 0 {
+		return nil
+	}
+
+	content, err := json.Marshal([]interface{}{time.Since(r.started).Seconds(), code, value})
+	if err != nil {
+		return err
+	}
+
+	return r.write(content)
+}
+
+// write adds a line to the recording
+func (r *Recorder) write(content []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.writer.Write(content)
+	r.writer.WriteByte('\n')
+
+	return r.writer.Flush()
+}
+
+// Close closes the recording
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.writer.Flush()
+
+	return r.file.Close()
+}